@@ -1,8 +1,8 @@
 package main
 
 import (
-	"errors"
 	"flag"
+	"net/http"
 	"os"
 	"time"
 
@@ -11,9 +11,14 @@ import (
 	"github.com/cloudfoundry-incubator/cf_http"
 	"github.com/cloudfoundry-incubator/consuladapter"
 	"github.com/cloudfoundry-incubator/receptor"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/config"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/health_check"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/instruments"
 	"github.com/cloudfoundry-incubator/runtime-metrics-server/metrics"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/model"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/prometheus"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
 	Bbs "github.com/cloudfoundry-incubator/runtime-schema/bbs"
-	"github.com/cloudfoundry-incubator/runtime-schema/bbs/lock_bbs"
 	"github.com/cloudfoundry/dropsonde"
 	"github.com/cloudfoundry/storeadapter/etcdstoreadapter"
 	"github.com/nu7hatch/gouuid"
@@ -21,79 +26,80 @@ import (
 	"github.com/pivotal-golang/lager"
 	"github.com/tedsuo/ifrit"
 	"github.com/tedsuo/ifrit/grouper"
+	"github.com/tedsuo/ifrit/http_server"
 	"github.com/tedsuo/ifrit/sigmon"
 )
 
-var diegoAPIURL = flag.String(
-	"diegoAPIURL",
+var configFile = flag.String(
+	"config",
 	"",
-	"URL of diego API",
+	"JSON or YAML file to read the settings from; flags given override it",
 )
 
-var reportInterval = flag.Duration(
-	"reportInterval",
-	time.Minute,
-	"interval on which to report metrics",
-)
-
-var consulCluster = flag.String(
-	"consulCluster",
-	"",
-	"comma-separated list of consul server URLs (scheme://ip:port)",
-)
-
-var lockTTL = flag.Duration(
-	"lockTTL",
-	lock_bbs.LockTTL,
-	"TTL for service lock",
-)
-
-var lockRetryInterval = flag.Duration(
-	"lockRetryInterval",
-	lock_bbs.RetryInterval,
-	"interval to wait before retrying a failed lock acquisition",
-)
-
-var dropsondeOrigin = flag.String(
-	"dropsondeOrigin",
-	"runtime_metrics_server",
-	"Origin identifier for dropsonde-emitted metrics.",
-)
-
-var dropsondeDestination = flag.String(
-	"dropsondeDestination",
-	"localhost:3457",
-	"Destination for dropsonde-emitted metrics.",
-)
-
-var communicationTimeout = flag.Duration(
-	"communicationTimeout",
-	10*time.Second,
-	"Timeout applied to all HTTP requests.",
-)
+var cfg = config.Defaults()
 
 func main() {
 	cf_debug_server.AddFlags(flag.CommandLine)
 	cf_lager.AddFlags(flag.CommandLine)
 	etcdFlags := etcdstoreadapter.AddFlags(flag.CommandLine)
+	cfg.AddFlags(flag.CommandLine)
 	flag.Parse()
 
-	cf_http.Initialize(*communicationTimeout)
+	// the flags given win over the config file, so parse them again once it
+	// has been read
+	var loadErr error
+	if *configFile != "" {
+		loadErr = cfg.LoadFile(*configFile)
+		if loadErr == nil {
+			loadErr = cfg.SetFlags(flag.CommandLine)
+		}
+
+		flag.Parse()
+	}
+
+	cf_http.Initialize(time.Duration(cfg.CommunicationTimeout))
 
 	logger, reconfigurableSink := cf_lager.New("runtime-metrics-server")
 
+	if loadErr != nil {
+		logger.Fatal("failed-to-load-config", loadErr)
+	}
+
+	validationErrs := cfg.Validate()
+
 	etcdOptions, err := etcdFlags.Validate()
 	if err != nil {
-		logger.Fatal("etcd-validation-failed", err)
+		validationErrs = append(validationErrs, err)
 	}
 
-	if *diegoAPIURL == "" {
-		logger.Fatal("No receptor URL specified", errors.New("no receptor URL"))
+	if len(validationErrs) > 0 {
+		logger.Fatal("invalid-config", validationErrs)
 	}
 
+	reportInterval := time.Duration(cfg.ReportInterval)
+
 	initializeDropsonde(logger)
 
-	diegoAPIClient := receptor.NewClient(*diegoAPIURL)
+	metricSink := sink.NewDropsondeSink()
+
+	var prometheusRegistry *prometheus.Registry
+	if cfg.PrometheusListenAddress != "" {
+		prometheusRegistry = initializePrometheus(longestInterval(reportInterval, cfg.Instruments))
+		metricSink = sink.NewFanOutSink(metricSink, prometheusRegistry)
+	}
+
+	diegoAPIClient := receptor.NewClient(cfg.DiegoAPIURL)
+
+	var source instruments.Source = diegoAPIClient
+	var lrpModel *model.Model
+	if cfg.ModelResyncInterval > 0 {
+		lrpModel = model.New(logger, diegoAPIClient, clock.NewClock(), time.Duration(cfg.ModelResyncInterval))
+		source = lrpModel
+	}
+
+	// reports and instruments that have not completed for a few report
+	// intervals, or a few of their own intervals, are stale
+	healthCheck := health_check.New(clock.NewClock(), 3*reportInterval)
 
 	metricsBBS := initializeMetricsBBS(logger)
 
@@ -101,19 +107,61 @@ func main() {
 	if err != nil {
 		logger.Fatal("Couldn't generate uuid", err)
 	}
-	lockMaintainer := metricsBBS.NewRuntimeMetricsLock(uuid.String(), *lockRetryInterval)
+	lockMaintainer := health_check.NewLockMonitor(
+		healthCheck,
+		metricsBBS.NewRuntimeMetricsLock(uuid.String(), time.Duration(cfg.LockRetryInterval)),
+	)
+
+	instrumentConfigs, err := cfg.InstrumentConfigs()
+	if err != nil {
+		logger.Fatal("invalid-config", err)
+	}
 
 	notifier := metrics.NewPeriodicMetronNotifier(
-		logger,
-		*reportInterval,
-		etcdOptions,
-		clock.NewClock(),
-		diegoAPIClient,
+		metrics.Settings{
+			Interval:          reportInterval,
+			Instruments:       instrumentSettings(cfg.Instruments, instrumentConfigs),
+			InstrumentTimeout: time.Duration(cfg.InstrumentTimeout),
+			MaxStaleness:      time.Duration(cfg.MaxStaleness),
+		},
+		instruments.Dependencies{
+			Logger:         logger,
+			Clock:          clock.NewClock(),
+			ReceptorClient: diegoAPIClient,
+			Source:         source,
+			FailurePolicy:  instruments.FailurePolicy(cfg.InstrumentFailurePolicy),
+			ETCDOptions:    etcdOptions,
+
+			TaskAgeThresholds:  cfg.TaskAgeThresholds.Durations(),
+			CrashLoopThreshold: cfg.CrashLoopThreshold,
+			KnownStacks:        cfg.KnownStacks,
+			ETCDAPIVersion:     instruments.ETCDAPIVersion(cfg.ETCDAPIVersion),
+		},
+		metricSink,
+		healthCheck,
 	)
 
 	members := grouper.Members{
 		{"lock-maintainer", lockMaintainer},
-		{"metrics", *notifier},
+	}
+
+	// only the lock holder follows the event stream
+	if lrpModel != nil {
+		members = append(members, grouper.Member{"model", lrpModel})
+	}
+
+	members = append(members, grouper.Member{"metrics", *notifier})
+
+	if cfg.HealthListenAddress != "" {
+		members = append(grouper.Members{
+			{"health-server", http_server.New(cfg.HealthListenAddress, health_check.NewHandler(healthCheck))},
+		}, members...)
+	}
+
+	if prometheusRegistry != nil {
+		members = append(grouper.Members{
+			{"prometheus-server", initializePrometheusServer(prometheusRegistry)},
+		}, members...)
 	}
 
 	if dbgAddr := cf_debug_server.DebugAddress(flag.CommandLine); dbgAddr != "" {
@@ -136,21 +184,64 @@ func main() {
 	}
 }
 
+func instrumentSettings(sections map[string]config.InstrumentConfig, configs map[string]instruments.Config) map[string]metrics.InstrumentSettings {
+	settings := make(map[string]metrics.InstrumentSettings, len(sections))
+	for name, instrument := range sections {
+		settings[name] = metrics.InstrumentSettings{
+			Disabled: instrument.Enabled != nil && !*instrument.Enabled,
+			Interval: time.Duration(instrument.Interval),
+			Config:   configs[name],
+		}
+	}
+
+	return settings
+}
+
+// longestInterval is how often the enabled instruments that run least often
+// run.
+func longestInterval(reportInterval time.Duration, instruments map[string]config.InstrumentConfig) time.Duration {
+	longest := reportInterval
+	for _, instrument := range instruments {
+		if instrument.Enabled != nil && !*instrument.Enabled {
+			continue
+		}
+
+		if interval := time.Duration(instrument.Interval); interval > longest {
+			longest = interval
+		}
+	}
+
+	return longest
+}
+
 func initializeDropsonde(logger lager.Logger) {
-	err := dropsonde.Initialize(*dropsondeDestination, *dropsondeOrigin)
+	err := dropsonde.Initialize(cfg.DropsondeDestination, cfg.DropsondeOrigin)
 	if err != nil {
 		logger.Error("failed to initialize dropsonde: %v", err)
 	}
 }
 
+func initializePrometheus(interval time.Duration) *prometheus.Registry {
+	// values that outlive a few runs of the instruments that run least often
+	// are stale, e.g. after the lock has been lost or a domain has gone away
+	return prometheus.NewRegistry(clock.NewClock(), 3*interval)
+}
+
+func initializePrometheusServer(registry *prometheus.Registry) ifrit.Runner {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", prometheus.NewHandler(registry))
+
+	return http_server.New(cfg.PrometheusListenAddress, mux)
+}
+
 func initializeMetricsBBS(logger lager.Logger) Bbs.MetricsBBS {
-	client, err := consuladapter.NewClient(*consulCluster)
+	client, err := consuladapter.NewClient(cfg.ConsulCluster)
 	if err != nil {
 		logger.Fatal("new-client-failed", err)
 	}
 
 	sessionMgr := consuladapter.NewSessionManager(client)
-	consulSession, err := consuladapter.NewSession("runtime-metrics-server", *lockTTL, client, sessionMgr)
+	consulSession, err := consuladapter.NewSession("runtime-metrics-server", time.Duration(cfg.LockTTL), client, sessionMgr)
 	if err != nil {
 		logger.Fatal("consul-session-failed", err)
 	}
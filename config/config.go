@@ -0,0 +1,403 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/instruments"
+	"github.com/cloudfoundry-incubator/runtime-schema/bbs/lock_bbs"
+	"gopkg.in/yaml.v2"
+)
+
+// Config holds the server's settings. They can be read from a JSON or YAML
+// file, and any flag given overrides the file.
+type Config struct {
+	DiegoAPIURL             string    `json:"diego_api_url" yaml:"diego_api_url"`
+	ReportInterval          Duration  `json:"report_interval" yaml:"report_interval"`
+	InstrumentTimeout       Duration  `json:"instrument_timeout" yaml:"instrument_timeout"`
+	InstrumentFailurePolicy string    `json:"instrument_failure_policy" yaml:"instrument_failure_policy"`
+	MaxStaleness            Duration  `json:"max_staleness" yaml:"max_staleness"`
+	TaskAgeThresholds       Durations `json:"task_age_thresholds" yaml:"task_age_thresholds"`
+	CrashLoopThreshold      int       `json:"crash_loop_threshold" yaml:"crash_loop_threshold"`
+	KnownStacks             List      `json:"known_stacks" yaml:"known_stacks"`
+	ModelResyncInterval     Duration  `json:"model_resync_interval" yaml:"model_resync_interval"`
+	ETCDAPIVersion          string    `json:"etcd_api_version" yaml:"etcd_api_version"`
+	ConsulCluster           string    `json:"consul_cluster" yaml:"consul_cluster"`
+	LockTTL                 Duration  `json:"lock_ttl" yaml:"lock_ttl"`
+	LockRetryInterval       Duration  `json:"lock_retry_interval" yaml:"lock_retry_interval"`
+	DropsondeOrigin         string    `json:"dropsonde_origin" yaml:"dropsonde_origin"`
+	DropsondeDestination    string    `json:"dropsonde_destination" yaml:"dropsonde_destination"`
+	PrometheusListenAddress string    `json:"prometheus_listen_address" yaml:"prometheus_listen_address"`
+	HealthListenAddress     string    `json:"health_listen_address" yaml:"health_listen_address"`
+	CommunicationTimeout    Duration  `json:"communication_timeout" yaml:"communication_timeout"`
+
+	// by instrument name; only read from the config file
+	Instruments map[string]InstrumentConfig `json:"instruments" yaml:"instruments"`
+
+	// settings for etcd, logging and the debug server, whose flags are
+	// registered by their own packages
+	ETCD         ETCDConfig `json:"etcd" yaml:"etcd"`
+	LogLevel     string     `json:"log_level" yaml:"log_level"`
+	DebugAddress string     `json:"debug_address" yaml:"debug_address"`
+}
+
+// InstrumentConfig selects whether an instrument runs, and how often. Unless
+// given, instruments are enabled and run on the report interval. Config is
+// the instrument's own settings, which it reads itself.
+type InstrumentConfig struct {
+	Enabled  *bool                  `json:"enabled" yaml:"enabled"`
+	Interval Duration               `json:"interval" yaml:"interval"`
+	Config   map[string]interface{} `json:"config" yaml:"config"`
+}
+
+type ETCDConfig struct {
+	ClusterURLs List   `json:"cluster_urls" yaml:"cluster_urls"`
+	CertFile    string `json:"cert_file" yaml:"cert_file"`
+	KeyFile     string `json:"key_file" yaml:"key_file"`
+	CAFile      string `json:"ca_file" yaml:"ca_file"`
+}
+
+func Defaults() *Config {
+	return &Config{
+		ReportInterval:          Duration(time.Minute),
+		InstrumentTimeout:       Duration(30 * time.Second),
+		InstrumentFailurePolicy: string(instruments.SentinelOnFailure),
+		TaskAgeThresholds:       Durations{Duration(5 * time.Minute), Duration(time.Hour)},
+		CrashLoopThreshold:      3,
+		KnownStacks:             List{"cflinuxfs2"},
+		ETCDAPIVersion:          string(instruments.ETCDAPIAuto),
+		LockTTL:                 Duration(lock_bbs.LockTTL),
+		LockRetryInterval:       Duration(lock_bbs.RetryInterval),
+		DropsondeOrigin:         "runtime_metrics_server",
+		DropsondeDestination:    "localhost:3457",
+		CommunicationTimeout:    Duration(10 * time.Second),
+	}
+}
+
+// AddFlags registers a flag for each of the server's own settings.
+func (c *Config) AddFlags(flagSet *flag.FlagSet) {
+	flagSet.StringVar(&c.DiegoAPIURL, "diegoAPIURL", c.DiegoAPIURL, "URL of diego API")
+	flagSet.Var(&c.ReportInterval, "reportInterval", "interval on which to report metrics")
+	flagSet.Var(&c.InstrumentTimeout, "instrumentTimeout", "time to wait for each instrument to collect its metrics before reporting without it (0 waits indefinitely)")
+	flagSet.StringVar(&c.InstrumentFailurePolicy, "instrumentFailurePolicy", c.InstrumentFailurePolicy, "what instruments report when they fail to collect: sentinel (-1) or skip (nothing)")
+	flagSet.Var(&c.MaxStaleness, "maxStaleness", "how long to keep reporting an instrument's last good values, tagged as stale, while it fails (disabled if zero)")
+	flagSet.Var(&c.TaskAgeThresholds, "taskAgeThresholds", "comma-separated task ages, e.g. 5m,1h; the tasks older than each are counted per state")
+	flagSet.IntVar(&c.CrashLoopThreshold, "crashLoopThreshold", c.CrashLoopThreshold, "number of crashes after which an actual LRP backing off is reported as crash looping")
+	flagSet.Var(&c.KnownStacks, "knownStacks", "comma-separated preloaded stacks to report separately; the desired LRPs and tasks on any other rootfs are reported as docker or other")
+	flagSet.Var(&c.ModelResyncInterval, "modelResyncInterval", "follow receptor's event stream to keep the LRPs in memory, listing them in full on this interval, instead of listing them on every report (disabled if zero)")
+	flagSet.StringVar(&c.ETCDAPIVersion, "etcdAPIVersion", c.ETCDAPIVersion, "etcd API to gather stats from: v2, v3, or auto to ask the cluster")
+	flagSet.StringVar(&c.ConsulCluster, "consulCluster", c.ConsulCluster, "comma-separated list of consul server URLs (scheme://ip:port)")
+	flagSet.Var(&c.LockTTL, "lockTTL", "TTL for service lock")
+	flagSet.Var(&c.LockRetryInterval, "lockRetryInterval", "interval to wait before retrying a failed lock acquisition")
+	flagSet.StringVar(&c.DropsondeOrigin, "dropsondeOrigin", c.DropsondeOrigin, "Origin identifier for dropsonde-emitted metrics.")
+	flagSet.StringVar(&c.DropsondeDestination, "dropsondeDestination", c.DropsondeDestination, "Destination for dropsonde-emitted metrics.")
+	flagSet.StringVar(&c.PrometheusListenAddress, "prometheusListenAddress", c.PrometheusListenAddress, "host:port on which to serve metrics in the Prometheus exposition format at /metrics (disabled if empty)")
+	flagSet.StringVar(&c.HealthListenAddress, "healthListenAddress", c.HealthListenAddress, "host:port on which to serve /health and /ready (disabled if empty)")
+	flagSet.Var(&c.CommunicationTimeout, "communicationTimeout", "Timeout applied to all HTTP requests.")
+}
+
+// LoadFile reads the settings in a JSON file, or a YAML one if it is named
+// *.yml or *.yaml, over the current ones. Unknown settings are an error, as
+// they are most likely misspelt.
+func (c *Config) LoadFile(path string) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch filepath.Ext(path) {
+	case ".yml", ".yaml":
+		err = yaml.UnmarshalStrict(contents, c)
+	default:
+		decoder := json.NewDecoder(bytes.NewReader(contents))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(c)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid config file %s: %s", path, err)
+	}
+
+	return nil
+}
+
+// SetFlags sets the flags registered by the etcd, lager and debug server
+// packages to the settings given for them, so that they are still read from
+// those flags. Parse the command line again afterwards for the flags given
+// there to win.
+func (c *Config) SetFlags(flagSet *flag.FlagSet) error {
+	values := map[string]string{
+		"etcdCertFile": c.ETCD.CertFile,
+		"etcdKeyFile":  c.ETCD.KeyFile,
+		"etcdCaFile":   c.ETCD.CAFile,
+		"logLevel":     c.LogLevel,
+		"debugAddr":    c.DebugAddress,
+	}
+
+	if len(c.ETCD.ClusterURLs) > 0 {
+		values["etcdCluster"] = c.ETCD.ClusterURLs.String()
+	}
+
+	for name, value := range values {
+		if value == "" {
+			continue
+		}
+
+		err := flagSet.Set(name, value)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+
+	return nil
+}
+
+// InstrumentConfigs returns the config of each instrument given one in the
+// config file, as JSON. The built-in instruments' settings are the server's
+// own, so they are not among them.
+func (c *Config) InstrumentConfigs() (map[string]instruments.Config, error) {
+	configs := map[string]instruments.Config{}
+	for name, instrument := range c.Instruments {
+		if instrument.Config == nil {
+			continue
+		}
+
+		config, err := encodeConfig(instrument.Config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config of instrument %s: %s", name, err)
+		}
+
+		configs[name] = config
+	}
+
+	return configs, nil
+}
+
+// checkInstrumentConfig checks an instrument's config as it would be given to
+// the instrument.
+func checkInstrumentConfig(name string, section map[string]interface{}) error {
+	config, err := encodeConfig(section)
+	if err != nil {
+		return fmt.Errorf("invalid config of instrument %s: %s", name, err)
+	}
+
+	return instruments.CheckConfig(name, config)
+}
+
+func encodeConfig(config map[string]interface{}) (instruments.Config, error) {
+	encoded, err := json.Marshal(jsonValue(config))
+	if err != nil {
+		return nil, err
+	}
+
+	return instruments.Config(encoded), nil
+}
+
+// jsonValue converts the maps read from YAML, which are keyed by anything,
+// into ones JSON can encode.
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			converted[fmt.Sprint(key)] = jsonValue(element)
+		}
+		return converted
+
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			converted[key] = jsonValue(element)
+		}
+		return converted
+
+	case []interface{}:
+		converted := make([]interface{}, 0, len(v))
+		for _, element := range v {
+			converted = append(converted, jsonValue(element))
+		}
+		return converted
+
+	default:
+		return value
+	}
+}
+
+// Errors are all the problems found with a configuration.
+type Errors []error
+
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+
+	return "invalid configuration: " + strings.Join(messages, "; ")
+}
+
+// Validate checks every setting, and reports all the problems found.
+func (c *Config) Validate() Errors {
+	var errs Errors
+
+	if c.DiegoAPIURL == "" {
+		errs = append(errs, errors.New("no receptor URL specified"))
+	}
+
+	if c.ReportInterval <= 0 {
+		errs = append(errs, errors.New("report interval must be positive"))
+	}
+
+	for name, d := range map[string]Duration{
+		"instrument timeout":    c.InstrumentTimeout,
+		"max staleness":         c.MaxStaleness,
+		"model resync interval": c.ModelResyncInterval,
+	} {
+		if d < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", name))
+		}
+	}
+
+	err := instruments.FailurePolicy(c.InstrumentFailurePolicy).Validate()
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	err = instruments.ETCDAPIVersion(c.ETCDAPIVersion).Validate()
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, threshold := range c.TaskAgeThresholds {
+		if threshold <= 0 {
+			errs = append(errs, fmt.Errorf("task age threshold %s must be positive", threshold))
+		}
+	}
+
+	if c.CrashLoopThreshold < 0 {
+		errs = append(errs, errors.New("crash loop threshold must not be negative"))
+	}
+
+	for name, instrument := range c.Instruments {
+		if !instruments.IsRegistered(name) {
+			errs = append(errs, fmt.Errorf("unknown instrument: %q", name))
+		} else if instrument.Config != nil {
+			err := checkInstrumentConfig(name, instrument.Config)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		if instrument.Interval < 0 {
+			errs = append(errs, fmt.Errorf("interval of instrument %s must not be negative", name))
+		}
+	}
+
+	return errs
+}
+
+// Duration reads as e.g. "30s" in files and flags.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *Duration) Set(value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return err
+	}
+
+	return d.Set(value)
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	err := unmarshal(&value)
+	if err != nil {
+		return err
+	}
+
+	return d.Set(value)
+}
+
+// Durations read as a list in files, and comma-separated in flags.
+type Durations []Duration
+
+func (d Durations) String() string {
+	values := make([]string, 0, len(d))
+	for _, duration := range d {
+		values = append(values, duration.String())
+	}
+
+	return strings.Join(values, ",")
+}
+
+func (d Durations) Durations() []time.Duration {
+	durations := make([]time.Duration, 0, len(d))
+	for _, duration := range d {
+		durations = append(durations, time.Duration(duration))
+	}
+
+	return durations
+}
+
+func (d *Durations) Set(value string) error {
+	var durations Durations
+
+	for _, field := range splitList(value) {
+		var duration Duration
+		err := duration.Set(field)
+		if err != nil {
+			return err
+		}
+
+		durations = append(durations, duration)
+	}
+
+	*d = durations
+	return nil
+}
+
+// List reads as a list in files, and comma-separated in flags.
+type List []string
+
+func (l List) String() string {
+	return strings.Join(l, ",")
+}
+
+func (l *List) Set(value string) error {
+	*l = splitList(value)
+	return nil
+}
+
+func splitList(list string) []string {
+	var fields []string
+
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field != "" {
+			fields = append(fields, field)
+		}
+	}
+
+	return fields
+}
@@ -0,0 +1,225 @@
+package config_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	var (
+		cfg     *config.Config
+		flagSet *flag.FlagSet
+		dir     string
+	)
+
+	writeFile := func(name, contents string) string {
+		path := filepath.Join(dir, name)
+		Expect(ioutil.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+		return path
+	}
+
+	BeforeEach(func() {
+		cfg = config.Defaults()
+		cfg.DiegoAPIURL = "http://receptor.example.com"
+
+		flagSet = flag.NewFlagSet("test", flag.ContinueOnError)
+		cfg.AddFlags(flagSet)
+
+		var err error
+		dir, err = ioutil.TempDir("", "config")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Describe("LoadFile", func() {
+		It("reads JSON files", func() {
+			path := writeFile("config.json", `{
+				"report_interval": "10s",
+				"task_age_thresholds": ["1m", "2h"],
+				"known_stacks": ["cflinuxfs2", "windows2012R2"],
+				"etcd": {"cluster_urls": ["http://etcd-1:4001", "http://etcd-2:4001"]}
+			}`)
+
+			Expect(cfg.LoadFile(path)).To(Succeed())
+
+			Expect(cfg.ReportInterval).To(Equal(config.Duration(10 * time.Second)))
+			Expect(cfg.TaskAgeThresholds.Durations()).To(Equal([]time.Duration{time.Minute, 2 * time.Hour}))
+			Expect(cfg.KnownStacks).To(Equal(config.List{"cflinuxfs2", "windows2012R2"}))
+			Expect(cfg.ETCD.ClusterURLs).To(Equal(config.List{"http://etcd-1:4001", "http://etcd-2:4001"}))
+		})
+
+		It("reads YAML files", func() {
+			path := writeFile("config.yml", `
+report_interval: 10s
+crash_loop_threshold: 5
+log_level: debug
+`)
+
+			Expect(cfg.LoadFile(path)).To(Succeed())
+
+			Expect(cfg.ReportInterval).To(Equal(config.Duration(10 * time.Second)))
+			Expect(cfg.CrashLoopThreshold).To(Equal(5))
+			Expect(cfg.LogLevel).To(Equal("debug"))
+		})
+
+		It("reads the settings of each instrument", func() {
+			path := writeFile("config.yml", `
+instruments:
+  etcd:
+    interval: 10s
+  routes:
+    enabled: false
+`)
+
+			Expect(cfg.LoadFile(path)).To(Succeed())
+
+			Expect(cfg.Instruments).To(HaveLen(2))
+			Expect(cfg.Instruments["etcd"].Interval).To(Equal(config.Duration(10 * time.Second)))
+			Expect(cfg.Instruments["etcd"].Enabled).To(BeNil())
+			Expect(*cfg.Instruments["routes"].Enabled).To(BeFalse())
+		})
+
+		It("keeps the settings the file leaves out", func() {
+			path := writeFile("config.json", `{"crash_loop_threshold": 5}`)
+
+			Expect(cfg.LoadFile(path)).To(Succeed())
+
+			Expect(cfg.DiegoAPIURL).To(Equal("http://receptor.example.com"))
+			Expect(cfg.ReportInterval).To(Equal(config.Duration(time.Minute)))
+		})
+
+		It("rejects unknown settings", func() {
+			path := writeFile("config.yaml", "report_intreval: 10s\n")
+			Expect(cfg.LoadFile(path)).NotTo(Succeed())
+
+			path = writeFile("config.json", `{"report_intreval": "10s"}`)
+			Expect(cfg.LoadFile(path)).NotTo(Succeed())
+		})
+
+		It("rejects invalid durations", func() {
+			path := writeFile("config.json", `{"report_interval": "often"}`)
+			Expect(cfg.LoadFile(path)).NotTo(Succeed())
+		})
+
+		It("lets the flags given override the file", func() {
+			path := writeFile("config.json", `{"report_interval": "10s", "crash_loop_threshold": 5}`)
+			args := []string{"-reportInterval", "20s", "-taskAgeThresholds", "1m"}
+
+			Expect(flagSet.Parse(args)).To(Succeed())
+			Expect(cfg.LoadFile(path)).To(Succeed())
+			Expect(flagSet.Parse(args)).To(Succeed())
+
+			Expect(cfg.ReportInterval).To(Equal(config.Duration(20 * time.Second)))
+			Expect(cfg.TaskAgeThresholds.Durations()).To(Equal([]time.Duration{time.Minute}))
+			Expect(cfg.CrashLoopThreshold).To(Equal(5))
+		})
+	})
+
+	Describe("SetFlags", func() {
+		var logLevel, etcdCluster *string
+
+		BeforeEach(func() {
+			logLevel = flagSet.String("logLevel", "info", "")
+			etcdCluster = flagSet.String("etcdCluster", "http://127.0.0.1:4001", "")
+		})
+
+		It("sets the other packages' flags to the settings given for them", func() {
+			cfg.LogLevel = "debug"
+			cfg.ETCD.ClusterURLs = config.List{"http://etcd-1:4001", "http://etcd-2:4001"}
+
+			Expect(cfg.SetFlags(flagSet)).To(Succeed())
+
+			Expect(*logLevel).To(Equal("debug"))
+			Expect(*etcdCluster).To(Equal("http://etcd-1:4001,http://etcd-2:4001"))
+		})
+
+		It("leaves the flags alone for the settings not given", func() {
+			Expect(cfg.SetFlags(flagSet)).To(Succeed())
+
+			Expect(*logLevel).To(Equal("info"))
+			Expect(*etcdCluster).To(Equal("http://127.0.0.1:4001"))
+		})
+	})
+
+	Describe("InstrumentConfigs", func() {
+		It("gives each instrument its own section of the config file", func() {
+			path := writeFile("config.yml", `
+instruments:
+  etcd:
+    interval: 10s
+  custom:
+    config:
+      endpoint: http://example.com
+      limits:
+        requests: 10
+`)
+
+			Expect(cfg.LoadFile(path)).To(Succeed())
+
+			configs, err := cfg.InstrumentConfigs()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(configs).To(HaveLen(1))
+			Expect([]byte(configs["custom"])).To(MatchJSON(`{
+				"endpoint": "http://example.com",
+				"limits": {"requests": 10}
+			}`))
+		})
+	})
+
+	Describe("Validate", func() {
+		It("accepts the defaults", func() {
+			Expect(cfg.Validate()).To(BeEmpty())
+		})
+
+		It("reports every problem at once", func() {
+			cfg.DiegoAPIURL = ""
+			cfg.ReportInterval = 0
+			cfg.InstrumentFailurePolicy = "explode"
+			cfg.ETCDAPIVersion = "v4"
+			cfg.CrashLoopThreshold = -1
+			cfg.Instruments = map[string]config.InstrumentConfig{
+				"etcd": {Interval: config.Duration(-time.Second)},
+			}
+
+			errs := cfg.Validate()
+			Expect(errs).To(HaveLen(6))
+
+			Expect(errs.Error()).To(ContainSubstring("no receptor URL"))
+			Expect(errs.Error()).To(ContainSubstring(`invalid failure policy: "explode"`))
+			Expect(errs.Error()).To(ContainSubstring(`invalid etcd API version: "v4"`))
+		})
+
+		It("rejects configs the instruments do not accept", func() {
+			cfg.Instruments = map[string]config.InstrumentConfig{
+				"lrps": {Config: map[string]interface{}{"crash_loop_threshold": 7}},
+				"etcd": {Config: map[string]interface{}{"api_version": "v3"}},
+			}
+
+			Expect(cfg.Validate()).To(ConsistOf(
+				MatchError("instrument lrps takes no config"),
+				MatchError("instrument etcd takes no config"),
+			))
+		})
+
+		It("rejects settings for instruments that are not registered", func() {
+			cfg.Instruments = map[string]config.InstrumentConfig{
+				"etcd":  {},
+				"bogus": {},
+			}
+
+			Expect(cfg.Validate()).To(ConsistOf(MatchError(`unknown instrument: "bogus"`)))
+		})
+	})
+})
@@ -0,0 +1,37 @@
+package health_check
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// NewHandler serves the liveness of the server at /health and its readiness
+// at /ready, each with the full status as JSON. Unhealthy responses use
+// status 503.
+func NewHandler(healthCheck *HealthCheck) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		status := healthCheck.Status()
+		writeStatus(w, status, status.Live)
+	})
+
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		status := healthCheck.Status()
+		writeStatus(w, status, status.Ready)
+	})
+
+	return mux
+}
+
+func writeStatus(w http.ResponseWriter, status Status, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if ok {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(status)
+}
@@ -1,11 +1,180 @@
 package health_check
 
-type HealthCheck struct{}
+import (
+	"sync"
+	"time"
 
-func New() *HealthCheck {
-	return &HealthCheck{}
+	"github.com/pivotal-golang/clock"
+)
+
+type InstrumentStatus struct {
+	Dependency  string        `json:"dependency"`
+	Interval    time.Duration `json:"interval"`
+	Succeeded   bool          `json:"succeeded"`
+	LastSuccess time.Time     `json:"last_success"`
+	LastFailure time.Time     `json:"last_failure"`
+	LastError   string        `json:"last_error,omitempty"`
+}
+
+type DependencyStatus struct {
+	Reachable   bool      `json:"reachable"`
+	LastChecked time.Time `json:"last_checked"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+type Status struct {
+	Live         bool                        `json:"live"`
+	Ready        bool                        `json:"ready"`
+	LockHeld     bool                        `json:"lock_held"`
+	LastReport   time.Time                   `json:"last_report"`
+	Instruments  map[string]InstrumentStatus `json:"instruments"`
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+}
+
+// how many runs an instrument may go without succeeding before it is stale,
+// when that is longer than the staleness window
+const staleRuns = 3
+
+// HealthCheck tracks whether this server holds the metrics lock, when each
+// instrument last succeeded, and whether the endpoints the instruments talk
+// to were reachable. A dependency (e.g. "receptor" or "etcd") is reachable
+// when the latest run of every instrument that depends on it succeeded.
+//
+// The server is live unless it holds the lock but has not finished a report
+// within the staleness window. It is ready when it holds the lock, every
+// dependency was reachable on its last check, and every instrument has
+// succeeded within the staleness window, or within its last few runs if it
+// runs less often than that.
+type HealthCheck struct {
+	clock      clock.Clock
+	staleAfter time.Duration
+
+	lock        sync.RWMutex
+	lockHeld    bool
+	lockHeldAt  time.Time
+	lastReport  time.Time
+	instruments map[string]*InstrumentStatus
+}
+
+func New(clock clock.Clock, staleAfter time.Duration) *HealthCheck {
+	return &HealthCheck{
+		clock:       clock,
+		staleAfter:  staleAfter,
+		instruments: map[string]*InstrumentStatus{},
+	}
+}
+
+func (h *HealthCheck) SetLockHeld(held bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if held && !h.lockHeld {
+		h.lockHeldAt = h.clock.Now()
+	}
+
+	h.lockHeld = held
+}
+
+func (h *HealthCheck) ReportCompleted() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.lastReport = h.clock.Now()
+}
+
+func (h *HealthCheck) InstrumentSucceeded(name string, dependency string, interval time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	status := h.instrument(name, dependency, interval)
+	status.Succeeded = true
+	status.LastSuccess = h.clock.Now()
+}
+
+func (h *HealthCheck) InstrumentFailed(name string, dependency string, interval time.Duration, err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	status := h.instrument(name, dependency, interval)
+	status.Succeeded = false
+	status.LastFailure = h.clock.Now()
+	status.LastError = err.Error()
 }
 
 func (h *HealthCheck) Ok() bool {
-	return true
+	return h.Status().Ready
+}
+
+func (h *HealthCheck) Status() Status {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	now := h.clock.Now()
+
+	status := Status{
+		Live:         true,
+		Ready:        h.lockHeld,
+		LockHeld:     h.lockHeld,
+		LastReport:   h.lastReport,
+		Instruments:  make(map[string]InstrumentStatus, len(h.instruments)),
+		Dependencies: map[string]DependencyStatus{},
+	}
+
+	if h.lockHeld && h.stale(h.lastReport, now, 0) && h.stale(h.lockHeldAt, now, 0) {
+		status.Live = false
+		status.Ready = false
+	}
+
+	for name, instrument := range h.instruments {
+		status.Instruments[name] = *instrument
+
+		if h.stale(instrument.LastSuccess, now, instrument.Interval) {
+			status.Ready = false
+		}
+
+		dependency, found := status.Dependencies[instrument.Dependency]
+		if !found {
+			dependency.Reachable = true
+		}
+
+		lastChecked := instrument.LastSuccess
+		if !instrument.Succeeded {
+			lastChecked = instrument.LastFailure
+			dependency.Reachable = false
+			dependency.LastError = instrument.LastError
+			status.Ready = false
+		}
+
+		if lastChecked.After(dependency.LastChecked) {
+			dependency.LastChecked = lastChecked
+		}
+
+		status.Dependencies[instrument.Dependency] = dependency
+	}
+
+	return status
+}
+
+func (h *HealthCheck) instrument(name string, dependency string, interval time.Duration) *InstrumentStatus {
+	status, found := h.instruments[name]
+	if !found {
+		status = &InstrumentStatus{}
+		h.instruments[name] = status
+	}
+
+	status.Dependency = dependency
+	status.Interval = interval
+
+	return status
+}
+
+// stale is whether something that happens every interval last happened too
+// long ago.
+func (h *HealthCheck) stale(at time.Time, now time.Time, interval time.Duration) bool {
+	staleAfter := h.staleAfter
+	if staleRuns*interval > staleAfter {
+		staleAfter = staleRuns * interval
+	}
+
+	return now.Sub(at) > staleAfter
 }
@@ -0,0 +1,288 @@
+package health_check_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"time"
+
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/health_check"
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HealthCheck", func() {
+	const (
+		staleAfter = time.Minute
+		interval   = staleAfter / 3
+	)
+
+	var (
+		fakeClock   *fakeclock.FakeClock
+		healthCheck *health_check.HealthCheck
+	)
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Unix(123, 456))
+		healthCheck = health_check.New(fakeClock, staleAfter)
+	})
+
+	Describe("Status", func() {
+		Context("when the lock is not held", func() {
+			It("is live but not ready", func() {
+				status := healthCheck.Status()
+				Expect(status.Live).To(BeTrue())
+				Expect(status.Ready).To(BeFalse())
+				Expect(status.LockHeld).To(BeFalse())
+				Expect(healthCheck.Ok()).To(BeFalse())
+			})
+		})
+
+		Context("when the lock is held", func() {
+			BeforeEach(func() {
+				healthCheck.SetLockHeld(true)
+			})
+
+			Context("and every instrument succeeded", func() {
+				BeforeEach(func() {
+					healthCheck.InstrumentSucceeded("tasks", "receptor", interval)
+					healthCheck.InstrumentSucceeded("etcd", "etcd", interval)
+					healthCheck.ReportCompleted()
+				})
+
+				It("is live and ready", func() {
+					status := healthCheck.Status()
+					Expect(status.Live).To(BeTrue())
+					Expect(status.Ready).To(BeTrue())
+					Expect(status.LastReport).To(Equal(fakeClock.Now()))
+					Expect(healthCheck.Ok()).To(BeTrue())
+				})
+
+				It("reports every dependency as reachable", func() {
+					Expect(healthCheck.Status().Dependencies).To(Equal(map[string]health_check.DependencyStatus{
+						"receptor": {Reachable: true, LastChecked: fakeClock.Now()},
+						"etcd":     {Reachable: true, LastChecked: fakeClock.Now()},
+					}))
+				})
+
+				Context("when an instrument has not succeeded within the staleness window", func() {
+					BeforeEach(func() {
+						fakeClock.Increment(staleAfter / 2)
+						healthCheck.InstrumentSucceeded("tasks", "receptor", interval)
+						healthCheck.ReportCompleted()
+						fakeClock.Increment(staleAfter/2 + time.Second)
+					})
+
+					It("is live but not ready", func() {
+						status := healthCheck.Status()
+						Expect(status.Live).To(BeTrue())
+						Expect(status.Ready).To(BeFalse())
+					})
+				})
+
+				Context("when an instrument that runs less often than the staleness window has succeeded within its last few runs", func() {
+					BeforeEach(func() {
+						healthCheck.InstrumentSucceeded("domains", "receptor", time.Hour)
+						fakeClock.Increment(2 * time.Hour)
+						healthCheck.InstrumentSucceeded("tasks", "receptor", interval)
+						healthCheck.InstrumentSucceeded("etcd", "etcd", interval)
+						healthCheck.ReportCompleted()
+					})
+
+					It("is ready", func() {
+						Expect(healthCheck.Status().Ready).To(BeTrue())
+					})
+
+					Context("until it has missed them", func() {
+						BeforeEach(func() {
+							fakeClock.Increment(time.Hour + time.Second)
+							healthCheck.InstrumentSucceeded("tasks", "receptor", interval)
+							healthCheck.InstrumentSucceeded("etcd", "etcd", interval)
+							healthCheck.ReportCompleted()
+						})
+
+						It("is live but not ready", func() {
+							status := healthCheck.Status()
+							Expect(status.Live).To(BeTrue())
+							Expect(status.Ready).To(BeFalse())
+						})
+					})
+				})
+
+				Context("when no report has completed within the staleness window", func() {
+					BeforeEach(func() {
+						fakeClock.Increment(staleAfter + time.Second)
+					})
+
+					It("is neither live nor ready", func() {
+						status := healthCheck.Status()
+						Expect(status.Live).To(BeFalse())
+						Expect(status.Ready).To(BeFalse())
+					})
+				})
+			})
+
+			Context("and one of several instruments using a dependency failed", func() {
+				BeforeEach(func() {
+					healthCheck.InstrumentSucceeded("tasks", "receptor", interval)
+					healthCheck.InstrumentFailed("lrps", "receptor", interval, errors.New("connection refused"))
+					healthCheck.InstrumentSucceeded("domains", "receptor", interval)
+				})
+
+				It("reports the dependency as unreachable", func() {
+					dependency := healthCheck.Status().Dependencies["receptor"]
+					Expect(dependency.Reachable).To(BeFalse())
+					Expect(dependency.LastError).To(Equal("connection refused"))
+				})
+
+				It("records the instrument's failure", func() {
+					instrument := healthCheck.Status().Instruments["lrps"]
+					Expect(instrument.Succeeded).To(BeFalse())
+					Expect(instrument.LastFailure).To(Equal(fakeClock.Now()))
+					Expect(instrument.LastError).To(Equal("connection refused"))
+				})
+
+				It("is not ready", func() {
+					Expect(healthCheck.Status().Ready).To(BeFalse())
+				})
+
+				Context("when the instrument succeeds again", func() {
+					BeforeEach(func() {
+						healthCheck.InstrumentSucceeded("lrps", "receptor", interval)
+					})
+
+					It("reports the dependency as reachable", func() {
+						Expect(healthCheck.Status().Dependencies["receptor"].Reachable).To(BeTrue())
+						Expect(healthCheck.Status().Ready).To(BeTrue())
+					})
+				})
+			})
+		})
+	})
+
+	Describe("NewHandler", func() {
+		var server *httptest.Server
+
+		BeforeEach(func() {
+			server = httptest.NewServer(health_check.NewHandler(healthCheck))
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		get := func(path string) (int, health_check.Status) {
+			resp, err := http.Get(server.URL + path)
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+
+			Expect(resp.Header.Get("Content-Type")).To(Equal("application/json"))
+
+			var status health_check.Status
+			Expect(json.NewDecoder(resp.Body).Decode(&status)).To(Succeed())
+
+			return resp.StatusCode, status
+		}
+
+		Context("when the server is ready", func() {
+			BeforeEach(func() {
+				healthCheck.SetLockHeld(true)
+				healthCheck.InstrumentSucceeded("tasks", "receptor", interval)
+			})
+
+			It("responds OK to /health and /ready with the details", func() {
+				code, status := get("/health")
+				Expect(code).To(Equal(http.StatusOK))
+				Expect(status.Live).To(BeTrue())
+
+				code, status = get("/ready")
+				Expect(code).To(Equal(http.StatusOK))
+				Expect(status.Instruments).To(HaveKey("tasks"))
+				Expect(status.Dependencies["receptor"].Reachable).To(BeTrue())
+			})
+		})
+
+		Context("when the server is live but not ready", func() {
+			It("responds OK to /health and unavailable to /ready", func() {
+				code, _ := get("/health")
+				Expect(code).To(Equal(http.StatusOK))
+
+				code, status := get("/ready")
+				Expect(code).To(Equal(http.StatusServiceUnavailable))
+				Expect(status.LockHeld).To(BeFalse())
+			})
+		})
+	})
+
+	Describe("NewLockMonitor", func() {
+		var (
+			lockReady chan struct{}
+			lockExit  chan error
+			process   ifrit.Process
+		)
+
+		BeforeEach(func() {
+			lockReady = make(chan struct{})
+			lockExit = make(chan error, 1)
+
+			lockRunner := ifrit.RunFunc(func(signals <-chan os.Signal, ready chan<- struct{}) error {
+				select {
+				case <-lockReady:
+				case <-signals:
+					return nil
+				}
+
+				close(ready)
+
+				select {
+				case err := <-lockExit:
+					return err
+				case <-signals:
+					return nil
+				}
+			})
+
+			process = ifrit.Background(health_check.NewLockMonitor(healthCheck, lockRunner))
+		})
+
+		AfterEach(func() {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive())
+		})
+
+		It("does not mark the lock as held until it is acquired", func() {
+			Consistently(process.Ready()).ShouldNot(BeClosed())
+			Expect(healthCheck.Status().LockHeld).To(BeFalse())
+		})
+
+		Context("when the lock is acquired", func() {
+			BeforeEach(func() {
+				close(lockReady)
+			})
+
+			It("becomes ready and marks the lock as held", func() {
+				Eventually(process.Ready()).Should(BeClosed())
+				Expect(healthCheck.Status().LockHeld).To(BeTrue())
+			})
+
+			Context("and then lost", func() {
+				BeforeEach(func() {
+					Eventually(process.Ready()).Should(BeClosed())
+					lockExit <- errors.New("lost the lock")
+				})
+
+				It("marks the lock as not held and exits with the error", func() {
+					var err error
+					Eventually(process.Wait()).Should(Receive(&err))
+					Expect(err).To(MatchError("lost the lock"))
+					Expect(healthCheck.Status().LockHeld).To(BeFalse())
+				})
+			})
+		})
+	})
+})
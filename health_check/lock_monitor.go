@@ -0,0 +1,34 @@
+package health_check
+
+import (
+	"os"
+
+	"github.com/tedsuo/ifrit"
+)
+
+// NewLockMonitor wraps the runner that maintains the metrics lock, marking
+// the lock as held once the runner becomes ready and as lost once it exits.
+func NewLockMonitor(healthCheck *HealthCheck, lockRunner ifrit.Runner) ifrit.Runner {
+	return ifrit.RunFunc(func(signals <-chan os.Signal, ready chan<- struct{}) error {
+		lockReady := make(chan struct{})
+		exited := make(chan error, 1)
+
+		go func() {
+			exited <- lockRunner.Run(signals, lockReady)
+		}()
+
+		select {
+		case <-lockReady:
+		case err := <-exited:
+			return err
+		}
+
+		healthCheck.SetLockHeld(true)
+		close(ready)
+
+		err := <-exited
+		healthCheck.SetLockHeld(false)
+
+		return err
+	})
+}
@@ -0,0 +1,176 @@
+package instruments
+
+import (
+	"github.com/cloudfoundry-incubator/receptor"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
+	"github.com/pivotal-golang/lager"
+)
+
+const (
+	cellLRPsRunning        = "CellLRPsRunning"
+	cellTasksRunning       = "CellTasksRunning"
+	cellMemoryReserved     = "CellMemoryReserved"
+	cellMemoryCapacity     = "CellMemoryCapacity"
+	cellDiskReserved       = "CellDiskReserved"
+	cellDiskCapacity       = "CellDiskCapacity"
+	cellContainersReserved = "CellContainersReserved"
+	cellContainersCapacity = "CellContainersCapacity"
+
+	cellsPresent        = "CellsPresent"
+	cellsFreeMemory     = "CellsFreeMemory"
+	cellsFreeDisk       = "CellsFreeDisk"
+	cellsFreeContainers = "CellsFreeContainers"
+)
+
+type cellUsage struct {
+	capacity receptor.CellCapacity
+
+	lrpsRunning  int
+	tasksRunning int
+
+	memoryMB   int
+	diskMB     int
+	containers int
+}
+
+func (u *cellUsage) reserve(memoryMB, diskMB int) {
+	u.memoryMB += memoryMB
+	u.diskMB += diskMB
+	u.containers++
+}
+
+type cellInstrument struct {
+	logger         lager.Logger
+	receptorClient receptor.Client
+	source         Source
+	failurePolicy  FailurePolicy
+	sink           sink.Sink
+
+	cells tracker
+}
+
+func NewCellInstrument(logger lager.Logger, receptorClient receptor.Client, source Source, failurePolicy FailurePolicy, metricSink sink.Sink) Instrument {
+	return &cellInstrument{logger: logger, receptorClient: receptorClient, source: source, failurePolicy: failurePolicy, sink: metricSink}
+}
+
+func (t *cellInstrument) Send() error {
+	usage, err := t.collect()
+	if err != nil {
+		t.failurePolicy.sendFailed(t.sink, sink.Metric, cellsPresent, cellsFreeContainers)
+		t.failurePolicy.sendFailed(t.sink, sink.Mebibytes, cellsFreeMemory, cellsFreeDisk)
+		return err
+	}
+
+	var freeMemoryMB, freeDiskMB, freeContainers int
+
+	present := stringSet{}
+	for cellID, u := range usage {
+		present.add(cellID)
+
+		freeMemoryMB += nonNegative(u.capacity.MemoryMB - u.memoryMB)
+		freeDiskMB += nonNegative(u.capacity.DiskMB - u.diskMB)
+		freeContainers += nonNegative(u.capacity.Containers - u.containers)
+	}
+
+	for cellID := range t.cells.track(present, true) {
+		u, found := usage[cellID]
+		if !found {
+			u = &cellUsage{}
+		}
+
+		tags := sink.Tags{"cell": cellID}
+
+		t.sink.Gauge(cellLRPsRunning, float64(u.lrpsRunning), sink.Metric, tags)
+		t.sink.Gauge(cellTasksRunning, float64(u.tasksRunning), sink.Metric, tags)
+		t.sink.Gauge(cellMemoryReserved, float64(u.memoryMB), sink.Mebibytes, tags)
+		t.sink.Gauge(cellMemoryCapacity, float64(u.capacity.MemoryMB), sink.Mebibytes, tags)
+		t.sink.Gauge(cellDiskReserved, float64(u.diskMB), sink.Mebibytes, tags)
+		t.sink.Gauge(cellDiskCapacity, float64(u.capacity.DiskMB), sink.Mebibytes, tags)
+		t.sink.Gauge(cellContainersReserved, float64(u.containers), sink.Metric, tags)
+		t.sink.Gauge(cellContainersCapacity, float64(u.capacity.Containers), sink.Metric, tags)
+	}
+
+	t.sink.Gauge(cellsPresent, float64(len(usage)), sink.Metric, nil)
+	t.sink.Gauge(cellsFreeMemory, float64(freeMemoryMB), sink.Mebibytes, nil)
+	t.sink.Gauge(cellsFreeDisk, float64(freeDiskMB), sink.Mebibytes, nil)
+	t.sink.Gauge(cellsFreeContainers, float64(freeContainers), sink.Metric, nil)
+
+	return nil
+}
+
+// collect attributes every claimed or running LRP instance and every running
+// task to the cell it was placed on. LRP instances reserve the memory and disk
+// of their DesiredLRP.
+func (t *cellInstrument) collect() (map[string]*cellUsage, error) {
+	cells, err := t.receptorClient.Cells()
+	if err != nil {
+		t.logger.Error("failed-to-get-cells", err)
+		return nil, err
+	}
+
+	desiredLRPs, err := t.source.DesiredLRPs()
+	if err != nil {
+		t.logger.Error("failed-to-get-desired-lrps", err)
+		return nil, err
+	}
+
+	actualLRPs, err := t.source.ActualLRPs()
+	if err != nil {
+		t.logger.Error("failed-to-get-actual-lrps", err)
+		return nil, err
+	}
+
+	tasks, err := t.source.Tasks()
+	if err != nil {
+		t.logger.Error("failed-to-get-tasks", err)
+		return nil, err
+	}
+
+	usage := make(map[string]*cellUsage, len(cells))
+	for _, cell := range cells {
+		usage[cell.CellID] = &cellUsage{capacity: cell.Capacity}
+	}
+
+	desiredByGuid := make(map[string]receptor.DesiredLRPResponse, len(desiredLRPs))
+	for _, lrp := range desiredLRPs {
+		desiredByGuid[lrp.ProcessGuid] = lrp
+	}
+
+	for _, lrp := range actualLRPs {
+		u, found := usage[lrp.CellID]
+		if !found {
+			continue
+		}
+
+		// claimed instances hold their resources on the cell while they start
+		if lrp.State != receptor.ActualLRPStateRunning && lrp.State != receptor.ActualLRPStateClaimed {
+			continue
+		}
+
+		if lrp.State == receptor.ActualLRPStateRunning {
+			u.lrpsRunning++
+		}
+
+		desired := desiredByGuid[lrp.ProcessGuid]
+		u.reserve(desired.MemoryMB, desired.DiskMB)
+	}
+
+	for _, task := range tasks {
+		u, found := usage[task.CellID]
+		if !found || task.State != receptor.TaskStateRunning {
+			continue
+		}
+
+		u.tasksRunning++
+		u.reserve(task.MemoryMB, task.DiskMB)
+	}
+
+	return usage, nil
+}
+
+func nonNegative(value int) int {
+	if value < 0 {
+		return 0
+	}
+	return value
+}
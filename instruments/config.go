@@ -0,0 +1,23 @@
+package instruments
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Config is an instrument's own section of the server's config, as JSON, for
+// its factory to decode.
+type Config json.RawMessage
+
+// Decode reads the config into v, leaving v as it is if there is none.
+// Unknown settings are an error, as they are most likely misspelt.
+func (c Config) Decode(v interface{}) error {
+	if len(c) == 0 {
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(c))
+	decoder.DisallowUnknownFields()
+
+	return decoder.Decode(v)
+}
@@ -2,24 +2,44 @@ package instruments
 
 import (
 	"github.com/cloudfoundry-incubator/receptor"
-	"github.com/cloudfoundry-incubator/runtime-schema/metric"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
 )
 
-const domainMetricPrefix = "Domain."
+const domainMetric = "Domain"
 
 type domainInstrument struct {
 	receptorClient receptor.Client
+	sink           sink.Sink
+
+	domains tracker
 }
 
-func NewDomainInstrument(receptorClient receptor.Client) Instrument {
-	return &domainInstrument{receptorClient: receptorClient}
+func NewDomainInstrument(receptorClient receptor.Client, metricSink sink.Sink) Instrument {
+	return &domainInstrument{receptorClient: receptorClient, sink: metricSink}
 }
 
-func (t *domainInstrument) Send() {
-	// error intentionally dropped; report an empty set in the case of an error
-	domains, _ := t.receptorClient.Domains()
+// Send reports 1 for every fresh domain, and 0 for domains that were fresh on
+// the previous cycle but have since expired.
+func (t *domainInstrument) Send() error {
+	domains, err := t.receptorClient.Domains()
+	if err != nil {
+		// report nothing rather than marking every domain as expired
+		return err
+	}
 
+	fresh := stringSet{}
 	for _, domain := range domains {
-		metric.Metric(domainMetricPrefix + domain).Send(1)
+		fresh.add(domain)
+	}
+
+	for domain := range t.domains.track(fresh, true) {
+		freshness := 0.0
+		if fresh.contains(domain) {
+			freshness = 1
+		}
+
+		t.sink.Gauge(domainMetric, freshness, sink.Metric, sink.Tags{"domain": domain})
 	}
+
+	return nil
 }
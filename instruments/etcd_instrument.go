@@ -4,41 +4,112 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/cloudfoundry-incubator/cf_http"
-	"github.com/cloudfoundry-incubator/runtime-schema/metric"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
 	"github.com/cloudfoundry/gunk/urljoiner"
 	"github.com/cloudfoundry/storeadapter/etcdstoreadapter"
+	"github.com/pivotal-golang/clock"
 	"github.com/pivotal-golang/lager"
 )
 
 var errRedirected = errors.New("redirected to leader")
 
+// ETCDAPIVersion selects which etcd API the instrument gathers stats from.
+type ETCDAPIVersion string
+
+const (
+	ETCDAPIAuto ETCDAPIVersion = "auto"
+	ETCDAPIV2   ETCDAPIVersion = "v2"
+	ETCDAPIV3   ETCDAPIVersion = "v3"
+)
+
+func (v ETCDAPIVersion) Validate() error {
+	switch v {
+	case ETCDAPIAuto, ETCDAPIV2, ETCDAPIV3:
+		return nil
+	default:
+		return fmt.Errorf("invalid etcd API version: %q", v)
+	}
+}
+
 const (
-	etcdLeader                = metric.Metric("ETCDLeader")
-	etcdFollowers             = metric.Metric("ETCDFollowers")
-	etcdReceivedBandwidthRate = metric.BytesPerSecond("ETCDReceivedBandwidthRate")
-	etcdSentBandwidthRate     = metric.BytesPerSecond("ETCDSentBandwidthRate")
-	etcdReceivedRequestRate   = metric.RequestsPerSecond("ETCDReceivedRequestRate")
-	etcdSentRequestRate       = metric.RequestsPerSecond("ETCDSentRequestRate")
-	etcdRaftTerm              = metric.Metric("ETCDRaftTerm")
-	etcdWatchers              = metric.Metric("ETCDWatchers")
+	etcdLeader                = "ETCDLeader"
+	etcdFollowers             = "ETCDFollowers"
+	etcdReceivedBandwidthRate = "ETCDReceivedBandwidthRate"
+	etcdSentBandwidthRate     = "ETCDSentBandwidthRate"
+	etcdReceivedRequestRate   = "ETCDReceivedRequestRate"
+	etcdSentRequestRate       = "ETCDSentRequestRate"
+	etcdRaftTerm              = "ETCDRaftTerm"
+	etcdWatchers              = "ETCDWatchers"
+	etcdRaftIndex             = "ETCDRaftIndex"
+	etcdLeaderID              = "ETCDLeaderID"
+	etcdLeaderDisagreement    = "ETCDLeaderDisagreement"
+	etcdMemberIsLeader        = "ETCDMemberIsLeader"
+	etcdLeaderChanges         = "ETCDLeaderChanges"
+	etcdRaftTermIncrements    = "ETCDRaftTermIncrements"
+	etcdTimeSinceLeaderChange = "ETCDTimeSinceLeaderChange"
+	etcdDBSize                = "ETCDDBSize"
+
+	etcdFollowerLatencyCurrent           = "ETCDFollowerLatencyCurrent"
+	etcdFollowerLatencyAverage           = "ETCDFollowerLatencyAverage"
+	etcdFollowerLatencyStandardDeviation = "ETCDFollowerLatencyStandardDeviation"
+	etcdFollowerLatencyMinimum           = "ETCDFollowerLatencyMinimum"
+	etcdFollowerLatencyMaximum           = "ETCDFollowerLatencyMaximum"
+	etcdFollowerAppendFailures           = "ETCDFollowerAppendFailures"
+	etcdFollowerAppendSuccesses          = "ETCDFollowerAppendSuccesses"
+	etcdFollowerMaxLatency               = "ETCDFollowerMaxLatency"
+	etcdFollowerAppendFailureRate        = "ETCDFollowerAppendFailureRate"
 )
 
 type etcdInstrument struct {
 	logger lager.Logger
 
-	etcdCluster []string
+	etcdCluster   []string
+	apiVersion    ETCDAPIVersion
+	failurePolicy FailurePolicy
+
+	// where the v3 gateway was last found
+	gatewayPrefix string
 
 	client *http.Client
+	sink   sink.Sink
+
+	// append counts reported for each follower by the leader on the previous
+	// cycle, used to emit deltas
+	followerCounts map[string]etcdAppendCounts
+
+	leaders tracker
+
+	clock        clock.Clock
+	leader       string
+	leaderSince  time.Time
+	raftTerm     uint64
+	haveRaftTerm bool
+}
+
+// etcdMemberView is what one member reports about the cluster's leadership.
+type etcdMemberView struct {
+	index    int
+	id       string
+	leader   string
+	isLeader bool
 }
 
-func NewETCDInstrument(logger lager.Logger, etcdOptions *etcdstoreadapter.ETCDOptions) (Instrument, error) {
+func NewETCDInstrument(logger lager.Logger, etcdOptions *etcdstoreadapter.ETCDOptions, apiVersion ETCDAPIVersion, failurePolicy FailurePolicy, clock clock.Clock, metricSink sink.Sink) (Instrument, error) {
+	err := apiVersion.Validate()
+	if err != nil {
+		return nil, err
+	}
+
 	var tlsConfig *tls.Config
 	if etcdOptions.CertFile != "" && etcdOptions.KeyFile != "" {
-		var err error
 		tlsConfig, err = cf_http.NewTLSConfig(etcdOptions.CertFile, etcdOptions.KeyFile, etcdOptions.CAFile)
 		if err != nil {
 			return nil, err
@@ -59,26 +130,220 @@ func NewETCDInstrument(logger lager.Logger, etcdOptions *etcdstoreadapter.ETCDOp
 	return &etcdInstrument{
 		logger: logger,
 
-		etcdCluster: etcdOptions.ClusterUrls,
+		etcdCluster:   etcdOptions.ClusterUrls,
+		apiVersion:    apiVersion,
+		failurePolicy: failurePolicy,
 
 		client: client,
+		sink:   metricSink,
+
+		clock: clock,
 	}, nil
 }
 
-func (t *etcdInstrument) Send() {
-	for i, etcdAddr := range t.etcdCluster {
-		t.sendLeaderStats(etcdAddr, i)
-		// newEtcdNodeStats(etcdAddr, i, t.logger).Send()
+func (t *etcdInstrument) Send() error {
+	if t.resolveAPIVersion() == ETCDAPIV3 {
+		return t.sendV3()
+	}
+
+	return t.sendV2()
+}
+
+// resolveAPIVersion asks the cluster which API it speaks when configured to
+// detect it. Clusters that cannot answer are assumed to speak v2 until one
+// does.
+func (t *etcdInstrument) resolveAPIVersion() ETCDAPIVersion {
+	if t.apiVersion != ETCDAPIAuto {
+		return t.apiVersion
+	}
+
+	for _, etcdAddr := range t.etcdCluster {
+		version, err := t.detectAPIVersion(etcdAddr)
+		if err != nil {
+			t.logger.Error("failed-to-detect-api-version", err, lager.Data{"address": etcdAddr})
+			continue
+		}
+
+		t.logger.Info("detected-api-version", lager.Data{"address": etcdAddr, "version": version})
+		t.apiVersion = version
+
+		return version
+	}
+
+	return ETCDAPIV2
+}
+
+func (t *etcdInstrument) detectAPIVersion(etcdAddr string) (ETCDAPIVersion, error) {
+	resp, err := t.client.Get(t.versionEndpoint(etcdAddr))
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+
+	var version etcdVersion
+
+	err = json.NewDecoder(resp.Body).Decode(&version)
+	if err != nil {
+		// etcd 2.0 answers with plain text, e.g. "etcd 2.0.13"
+		return ETCDAPIV2, nil
+	}
+
+	clusterVersion := version.Cluster
+	if clusterVersion == "" {
+		clusterVersion = version.Server
+	}
+
+	if strings.HasPrefix(clusterVersion, "2.") {
+		return ETCDAPIV2, nil
+	}
+
+	return ETCDAPIV3, nil
+}
+
+func (t *etcdInstrument) sendV2() error {
+	views, firstErr := t.sendSelfStats()
+
+	if firstErr != nil && len(views) == 0 {
+		t.failurePolicy.sendFailed(t.sink, sink.Metric, etcdLeader, etcdLeaderDisagreement, etcdRaftTerm, etcdWatchers)
+		return firstErr
+	}
+
+	leaderIndex := t.sendLeadership(views)
+	if leaderIndex < 0 {
+		if firstErr != nil {
+			// the leader may be one of the members that could not be reached
+			t.failurePolicy.sendFailed(t.sink, sink.Metric, etcdLeader, etcdRaftTerm, etcdWatchers)
+		}
+
+		return firstErr
+	}
+
+	err := t.sendLeaderStats(t.etcdCluster[leaderIndex])
+	if firstErr == nil {
+		firstErr = err
+	}
+
+	return firstErr
+}
+
+// sendLeadership cross-checks the leader reported by every reachable member,
+// emitting each member's role and whether the members disagree. It returns
+// the index of the agreed leader, or -1 if there is none or it is not one of
+// the configured members.
+func (t *etcdInstrument) sendLeadership(views []etcdMemberView) int {
+	reportedLeaders := stringSet{}
+
+	for _, view := range views {
+		var role float64
+		if view.isLeader {
+			role = 1
+		}
+
+		t.sink.Gauge(etcdMemberIsLeader, role, sink.Metric, sink.Tags{"member": view.id})
+
+		reportedLeaders.add(view.leader)
+	}
+
+	var leader string
+	if len(reportedLeaders) == 1 {
+		for id := range reportedLeaders {
+			leader = id
+		}
+	}
+
+	if leader == "" {
+		t.logger.Info("no-agreed-leader", lager.Data{"reported-leaders": len(reportedLeaders)})
+		t.sink.Gauge(etcdLeaderDisagreement, 1, sink.Metric, nil)
+	} else {
+		t.sink.Gauge(etcdLeaderDisagreement, 0, sink.Metric, nil)
+	}
+
+	observed := stringSet{}
+	if leader != "" {
+		observed.add(leader)
+	}
+
+	for id := range t.leaders.track(observed, true) {
+		var current float64
+		if id == leader {
+			current = 1
+		}
+
+		t.sink.Gauge(etcdLeaderID, current, sink.Metric, sink.Tags{"leader": id})
+	}
+
+	if leader == "" {
+		return -1
+	}
+
+	t.trackLeader(leader)
+
+	for _, view := range views {
+		if view.id == leader {
+			t.sink.Gauge(etcdLeader, float64(view.index), sink.Metric, nil)
+			return view.index
+		}
+	}
+
+	return -1
+}
+
+// trackLeader counts leader changes between cycles. The first leader seen is
+// assumed to have just been elected.
+func (t *etcdInstrument) trackLeader(leader string) {
+	now := t.clock.Now()
+
+	if leader != t.leader {
+		if t.leader != "" {
+			t.sink.Counter(etcdLeaderChanges, 1, nil)
+		}
+
+		t.leader = leader
+		t.leaderSince = now
+	}
+
+	t.sink.Duration(etcdTimeSinceLeaderChange, now.Sub(t.leaderSince), nil)
+}
+
+// sendRaftTerm emits the term along with how many elections started since
+// the previous cycle.
+func (t *etcdInstrument) sendRaftTerm(raftTerm uint64) {
+	t.sink.Gauge(etcdRaftTerm, float64(raftTerm), sink.Metric, nil)
+
+	if t.haveRaftTerm && raftTerm > t.raftTerm {
+		t.sink.Counter(etcdRaftTermIncrements, raftTerm-t.raftTerm, nil)
+	}
+
+	t.raftTerm = raftTerm
+	t.haveRaftTerm = true
+}
+
+// sendLeaderStats emits the stats only the leader reports, or reports the
+// raft term and watchers as failed if they cannot be gathered.
+func (t *etcdInstrument) sendLeaderStats(etcdAddr string) error {
+	err := t.collectLeaderStats(etcdAddr)
+	if err != nil {
+		t.failurePolicy.sendFailed(t.sink, sink.Metric, etcdRaftTerm, etcdWatchers)
 	}
 
-	t.sendSelfStats()
+	return err
 }
 
-func (t *etcdInstrument) sendLeaderStats(etcdAddr string, index int) {
+func (t *etcdInstrument) collectLeaderStats(etcdAddr string) error {
 	resp, err := t.client.Get(t.leaderStatsEndpoint(etcdAddr))
+	if isRedirect(err) {
+		// leadership moved since the members were asked; try again next time
+		return nil
+	}
+
 	if err != nil {
 		t.logger.Error("failed-to-collect-stats", err)
-		return
+		return err
 	}
 
 	defer resp.Body.Close()
@@ -88,17 +353,17 @@ func (t *etcdInstrument) sendLeaderStats(etcdAddr string, index int) {
 	err = json.NewDecoder(resp.Body).Decode(&stats)
 	if err != nil {
 		t.logger.Error("failed-to-unmarshal-stats", err)
-		return
+		return err
 	}
 
-	etcdLeader.Send(index)
+	t.sendFollowerStats(stats)
 
 	var storeStats etcdStoreStats
 
 	resp, err = t.client.Get(t.storeStatsEndpoint(etcdAddr))
 	if err != nil {
 		t.logger.Error("failed-to-collect-stats", err)
-		return
+		return err
 	}
 
 	defer resp.Body.Close()
@@ -106,56 +371,118 @@ func (t *etcdInstrument) sendLeaderStats(etcdAddr string, index int) {
 	err = json.NewDecoder(resp.Body).Decode(&storeStats)
 	if err != nil {
 		t.logger.Error("failed-to-unmarshal-stats", err)
-		return
+		return err
 	}
 
 	resp, err = t.client.Get(t.keysEndpoint((etcdAddr)))
 	if err != nil {
 		t.logger.Error("failed-to-get-keys", err)
-		return
+		return err
 	}
 
 	resp.Body.Close()
 
 	raftTermHeader := resp.Header.Get("X-Raft-Term")
 
-	raftTerm, err := strconv.ParseInt(raftTermHeader, 10, 0)
+	raftTerm, err := strconv.ParseUint(raftTermHeader, 10, 64)
 	if err != nil {
 		t.logger.Error("failed-to-parse-raft-term", err, lager.Data{
 			"term": raftTermHeader,
 		})
-		return
+		return err
 	}
 
-	etcdRaftTerm.Send(int(raftTerm))
-	etcdWatchers.Send(int(storeStats.Watchers))
+	t.sendRaftTerm(raftTerm)
+	t.sink.Gauge(etcdWatchers, float64(storeStats.Watchers), sink.Metric, nil)
 
-	return
+	return nil
 }
 
-func (t *etcdInstrument) sendSelfStats() {
+func (t *etcdInstrument) sendFollowerStats(stats etcdLeaderStats) {
+	var maxLatency time.Duration
+	var failures, successes uint64
+	var haveDeltas bool
+
+	counts := make(map[string]etcdAppendCounts, len(stats.Followers))
+
+	for id, follower := range stats.Followers {
+		tags := sink.Tags{"follower": id}
+
+		latency := follower.Latency
+		t.sink.Duration(etcdFollowerLatencyCurrent, milliseconds(latency.Current), tags)
+		t.sink.Duration(etcdFollowerLatencyAverage, milliseconds(latency.Average), tags)
+		t.sink.Duration(etcdFollowerLatencyStandardDeviation, milliseconds(latency.StandardDeviation), tags)
+		t.sink.Duration(etcdFollowerLatencyMinimum, milliseconds(latency.Minimum), tags)
+		t.sink.Duration(etcdFollowerLatencyMaximum, milliseconds(latency.Maximum), tags)
+
+		if current := milliseconds(latency.Current); current > maxLatency {
+			maxLatency = current
+		}
+
+		counts[id] = follower.Counts
+
+		previous, found := t.followerCounts[id]
+		if !found {
+			continue
+		}
+
+		failed := follower.Counts.Fail - previous.Fail
+		succeeded := follower.Counts.Success - previous.Success
+		if follower.Counts.Fail < previous.Fail || follower.Counts.Success < previous.Success {
+			// the leader changed or restarted, so its counts started over
+			failed = follower.Counts.Fail
+			succeeded = follower.Counts.Success
+		}
+
+		t.sink.Counter(etcdFollowerAppendFailures, failed, tags)
+		t.sink.Counter(etcdFollowerAppendSuccesses, succeeded, tags)
+
+		failures += failed
+		successes += succeeded
+		haveDeltas = true
+	}
+
+	t.followerCounts = counts
+
+	t.sink.Duration(etcdFollowerMaxLatency, maxLatency, nil)
+
+	if haveDeltas {
+		var failureRate float64
+		if failures+successes > 0 {
+			failureRate = float64(failures) / float64(failures+successes)
+		}
+
+		t.sink.Gauge(etcdFollowerAppendFailureRate, failureRate, sink.Metric, nil)
+	}
+}
+
+// sendSelfStats sums the rates reported by every member and returns each
+// reachable member's view of the leadership.
+func (t *etcdInstrument) sendSelfStats() ([]etcdMemberView, error) {
+	var views []etcdMemberView
+	var firstErr error
+
 	var receivedRequestsPerSecond float64
 	var sentRequestsPerSecond float64
 
 	var receivedBandwidthRate float64
 	var sentBandwidthRate float64
 
-	for _, addr := range t.etcdCluster {
-		var selfStats etcdServerStats
-
-		resp, err := t.client.Get(t.selfStatsEndpoint(addr))
+	for i, addr := range t.etcdCluster {
+		selfStats, err := t.memberSelfStats(addr)
 		if err != nil {
-			t.logger.Error("failed-to-collect-stats", err)
-			return
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
 		}
 
-		defer resp.Body.Close()
-
-		err = json.NewDecoder(resp.Body).Decode(&selfStats)
-		if err != nil {
-			t.logger.Error("failed-to-unmarshal-stats", err)
-			return
-		}
+		views = append(views, etcdMemberView{
+			index:    i,
+			id:       selfStats.ID,
+			leader:   selfStats.LeaderInfo.Name,
+			isLeader: selfStats.State == "StateLeader",
+		})
 
 		if selfStats.RecvingPkgRate != nil {
 			receivedRequestsPerSecond += *selfStats.RecvingPkgRate
@@ -174,11 +501,55 @@ func (t *etcdInstrument) sendSelfStats() {
 		}
 	}
 
-	etcdSentBandwidthRate.Send(sentBandwidthRate)
-	etcdSentRequestRate.Send(float64(sentRequestsPerSecond))
+	if firstErr != nil {
+		// rates summed over some of the members would be misleading
+		t.failurePolicy.sendFailed(t.sink, sink.BytesPerSecond, etcdSentBandwidthRate, etcdReceivedBandwidthRate)
+		t.failurePolicy.sendFailed(t.sink, sink.RequestsPerSecond, etcdSentRequestRate, etcdReceivedRequestRate)
+		return views, firstErr
+	}
+
+	t.sink.Gauge(etcdSentBandwidthRate, sentBandwidthRate, sink.BytesPerSecond, nil)
+	t.sink.Gauge(etcdSentRequestRate, sentRequestsPerSecond, sink.RequestsPerSecond, nil)
 
-	etcdReceivedBandwidthRate.Send(receivedBandwidthRate)
-	etcdReceivedRequestRate.Send(float64(receivedRequestsPerSecond))
+	t.sink.Gauge(etcdReceivedBandwidthRate, receivedBandwidthRate, sink.BytesPerSecond, nil)
+	t.sink.Gauge(etcdReceivedRequestRate, receivedRequestsPerSecond, sink.RequestsPerSecond, nil)
+
+	return views, nil
+}
+
+func (t *etcdInstrument) memberSelfStats(etcdAddr string) (etcdServerStats, error) {
+	var selfStats etcdServerStats
+
+	resp, err := t.client.Get(t.selfStatsEndpoint(etcdAddr))
+	if err != nil {
+		t.logger.Error("failed-to-collect-stats", err, lager.Data{"address": etcdAddr})
+		return selfStats, err
+	}
+
+	defer resp.Body.Close()
+
+	err = json.NewDecoder(resp.Body).Decode(&selfStats)
+	if err != nil {
+		t.logger.Error("failed-to-unmarshal-stats", err, lager.Data{"address": etcdAddr})
+		return selfStats, err
+	}
+
+	return selfStats, nil
+}
+
+func isRedirect(err error) bool {
+	urlErr, ok := err.(*url.Error)
+	return ok && urlErr.Err == errRedirected
+}
+
+// milliseconds converts etcd's fractional millisecond latencies, rounding to
+// the nearest nanosecond.
+func milliseconds(ms float64) time.Duration {
+	return time.Duration(ms*float64(time.Millisecond) + 0.5)
+}
+
+func (t *etcdInstrument) versionEndpoint(etcdAddr string) string {
+	return urljoiner.Join(etcdAddr, "version")
 }
 
 func (t *etcdInstrument) leaderStatsEndpoint(etcdAddr string) string {
@@ -197,6 +568,11 @@ func (t *etcdInstrument) keysEndpoint(etcdAddr string) string {
 	return urljoiner.Join(etcdAddr, "v2", "keys")
 }
 
+type etcdVersion struct {
+	Server  string `json:"etcdserver"`
+	Cluster string `json:"etcdcluster"`
+}
+
 type etcdLeaderStats struct {
 	Leader    string `json:"leader"`
 	Followers map[string]struct {
@@ -209,15 +585,18 @@ type etcdLeaderStats struct {
 			Maximum           float64 `json:"maximum"`
 		} `json:"latency"`
 
-		Counts struct {
-			Fail    uint64 `json:"fail"`
-			Success uint64 `json:"success"`
-		} `json:"counts"`
+		Counts etcdAppendCounts `json:"counts"`
 	} `json:"followers"`
 }
 
+type etcdAppendCounts struct {
+	Fail    uint64 `json:"fail"`
+	Success uint64 `json:"success"`
+}
+
 type etcdServerStats struct {
 	Name  string `json:"name"`
+	ID    string `json:"id"`
 	State string `json:"state"`
 
 	LeaderInfo struct {
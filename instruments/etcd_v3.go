@@ -0,0 +1,259 @@
+package instruments
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
+	"github.com/cloudfoundry/gunk/urljoiner"
+	"github.com/pivotal-golang/lager"
+)
+
+// etcd v3 counts watchers per member and only reports them in /metrics
+const etcdV3WatchersMetric = "etcd_debugging_mvcc_watcher_total"
+
+// the gRPC gateway is served under /v3alpha by etcd 3.2, /v3beta by 3.3 and
+// /v3 from 3.4 on
+var etcdV3GatewayPrefixes = []string{"v3", "v3beta", "v3alpha"}
+
+var errGatewayNotFound = errors.New("gateway not found")
+
+// sendV3 gathers the v2 metrics that have a v3 equivalent through the
+// gRPC gateway. v3 does not report bandwidth and request rates or follower
+// latencies, so those are not emitted.
+func (t *etcdInstrument) sendV3() error {
+	var firstErr error
+	var views []etcdMemberView
+	var watchers float64
+	var foundWatchers bool
+
+	statuses := make(map[int]etcdV3Status, len(t.etcdCluster))
+
+	for i, etcdAddr := range t.etcdCluster {
+		status, err := t.memberStatus(etcdAddr)
+		if err != nil {
+			t.logger.Error("failed-to-collect-stats", err, lager.Data{"address": etcdAddr})
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		statuses[i] = status
+
+		var leader string
+		if status.Leader != 0 {
+			// a zero leader ID means the member does not know of a leader
+			leader = status.Leader.String()
+		}
+
+		views = append(views, etcdMemberView{
+			index:    i,
+			id:       status.Header.MemberID.String(),
+			leader:   leader,
+			isLeader: status.Leader == status.Header.MemberID,
+		})
+
+		memberWatchers, found, err := t.memberWatchers(etcdAddr)
+		if err != nil {
+			t.logger.Error("failed-to-collect-metrics", err, lager.Data{"address": etcdAddr})
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		if found {
+			watchers += memberWatchers
+			foundWatchers = true
+		}
+	}
+
+	if firstErr != nil && len(views) == 0 {
+		t.failurePolicy.sendFailed(t.sink, sink.Metric, etcdLeader, etcdLeaderDisagreement, etcdRaftTerm, etcdRaftIndex, etcdFollowers, etcdWatchers)
+		t.failurePolicy.sendFailed(t.sink, sink.Bytes, etcdDBSize)
+		return firstErr
+	}
+
+	// watchers summed over some of the members would be misleading
+	if firstErr != nil {
+		t.failurePolicy.sendFailed(t.sink, sink.Metric, etcdWatchers)
+	} else if foundWatchers {
+		t.sink.Gauge(etcdWatchers, watchers, sink.Metric, nil)
+	}
+
+	leaderIndex := t.sendLeadership(views)
+	if leaderIndex < 0 && firstErr != nil {
+		// the leader may be one of the members that could not be reached
+		t.failurePolicy.sendFailed(t.sink, sink.Metric, etcdLeader, etcdRaftTerm, etcdRaftIndex, etcdFollowers)
+		t.failurePolicy.sendFailed(t.sink, sink.Bytes, etcdDBSize)
+	}
+
+	if leaderIndex >= 0 {
+		leader := statuses[leaderIndex]
+		leaderAddr := t.etcdCluster[leaderIndex]
+
+		t.sendRaftTerm(uint64(leader.RaftTerm))
+		t.sink.Gauge(etcdRaftIndex, float64(leader.RaftIndex), sink.Metric, nil)
+		t.sink.Gauge(etcdDBSize, float64(leader.DBSize), sink.Bytes, nil)
+
+		var members etcdV3MemberList
+
+		err := t.postGateway(leaderAddr, &members, "cluster", "member", "list")
+		if err != nil {
+			t.logger.Error("failed-to-list-members", err, lager.Data{"address": leaderAddr})
+			if firstErr == nil {
+				firstErr = err
+			}
+
+			t.failurePolicy.sendFailed(t.sink, sink.Metric, etcdFollowers)
+		} else {
+			t.sink.Gauge(etcdFollowers, float64(len(members.Members)-1), sink.Metric, nil)
+		}
+	}
+
+	return firstErr
+}
+
+func (t *etcdInstrument) memberStatus(etcdAddr string) (etcdV3Status, error) {
+	var status etcdV3Status
+	err := t.postGateway(etcdAddr, &status, "maintenance", "status")
+	return status, err
+}
+
+// postGateway calls a gateway endpoint under the prefix the gateway was last
+// found at, looking for it under the others if it is not there, e.g. after the
+// cluster is upgraded.
+func (t *etcdInstrument) postGateway(etcdAddr string, response interface{}, path ...string) error {
+	prefixes := etcdV3GatewayPrefixes
+	if t.gatewayPrefix != "" {
+		prefixes = []string{t.gatewayPrefix}
+		for _, prefix := range etcdV3GatewayPrefixes {
+			if prefix != t.gatewayPrefix {
+				prefixes = append(prefixes, prefix)
+			}
+		}
+	}
+
+	var err error
+	for _, prefix := range prefixes {
+		err = t.post(urljoiner.Join(etcdAddr, append([]string{prefix}, path...)...), response)
+		if err != errGatewayNotFound {
+			if err == nil {
+				t.gatewayPrefix = prefix
+			}
+
+			return err
+		}
+	}
+
+	return err
+}
+
+func (t *etcdInstrument) memberWatchers(etcdAddr string) (float64, bool, error) {
+	resp, err := t.client.Get(t.metricsEndpoint(etcdAddr))
+	if err != nil {
+		return 0, false, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, false, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+
+	return findPrometheusValue(resp.Body, etcdV3WatchersMetric)
+}
+
+// post calls a gRPC gateway endpoint, which only accepts POSTs of the
+// JSON-encoded request; all of the requests used here are empty.
+func (t *etcdInstrument) post(endpoint string, response interface{}) error {
+	resp, err := t.client.Post(endpoint, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errGatewayNotFound
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+// findPrometheusValue scans the text exposition format for an unlabelled
+// sample of the named metric.
+func findPrometheusValue(r io.Reader, name string) (float64, bool, error) {
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != name {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return 0, false, err
+		}
+
+		return value, true, nil
+	}
+
+	return 0, false, scanner.Err()
+}
+
+func (t *etcdInstrument) metricsEndpoint(etcdAddr string) string {
+	return urljoiner.Join(etcdAddr, "metrics")
+}
+
+// etcdV3Status is the gateway's encoding of a StatusResponse, which keeps the
+// protobuf field names and quotes 64-bit integers.
+type etcdV3Status struct {
+	Header struct {
+		ClusterID jsonUint64 `json:"cluster_id"`
+		MemberID  jsonUint64 `json:"member_id"`
+	} `json:"header"`
+
+	Version   string     `json:"version"`
+	DBSize    jsonUint64 `json:"db_size"`
+	Leader    jsonUint64 `json:"leader"`
+	RaftIndex jsonUint64 `json:"raft_index"`
+	RaftTerm  jsonUint64 `json:"raft_term"`
+}
+
+type etcdV3MemberList struct {
+	Members []struct {
+		ID         jsonUint64 `json:"ID"`
+		Name       string     `json:"name"`
+		ClientURLs []string   `json:"clientURLs"`
+	} `json:"members"`
+}
+
+// jsonUint64 decodes both quoted and bare 64-bit integers.
+type jsonUint64 uint64
+
+func (n *jsonUint64) UnmarshalJSON(data []byte) error {
+	value, err := strconv.ParseUint(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		return err
+	}
+
+	*n = jsonUint64(value)
+	return nil
+}
+
+func (n jsonUint64) String() string {
+	return strconv.FormatUint(uint64(n), 10)
+}
@@ -0,0 +1,42 @@
+package instruments
+
+import (
+	"fmt"
+
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
+)
+
+// FailurePolicy decides what an instrument reports in place of the values it
+// failed to collect. Values tagged with e.g. a domain or a cell are never
+// reported on failure, as there is no telling which ones to report.
+type FailurePolicy string
+
+const (
+	// SkipOnFailure reports nothing, leaving the previous values in place.
+	SkipOnFailure FailurePolicy = "skip"
+
+	// SentinelOnFailure reports -1 for every untagged value.
+	SentinelOnFailure FailurePolicy = "sentinel"
+)
+
+const failureSentinel = -1
+
+func (p FailurePolicy) Validate() error {
+	switch p {
+	case SkipOnFailure, SentinelOnFailure:
+		return nil
+	default:
+		return fmt.Errorf("invalid failure policy: %q", p)
+	}
+}
+
+// sendFailed reports the named untagged gauges as failed.
+func (p FailurePolicy) sendFailed(metricSink sink.Sink, unit sink.Unit, names ...string) {
+	if p != SentinelOnFailure {
+		return
+	}
+
+	for _, name := range names {
+		metricSink.Gauge(name, failureSentinel, unit, nil)
+	}
+}
@@ -1,5 +1,7 @@
 package instruments
 
+// Instrument collects and emits a group of metrics. Send returns an error if
+// any of them could not be collected.
 type Instrument interface {
-	Send()
+	Send() error
 }
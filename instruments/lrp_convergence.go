@@ -0,0 +1,109 @@
+package instruments
+
+import (
+	"time"
+
+	"github.com/cloudfoundry-incubator/receptor"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
+)
+
+const (
+	missingLRPInstances     = "LRPInstancesMissing"
+	extraLRPInstances       = "LRPInstancesExtra"
+	convergedLRPs           = "LRPsConverged"
+	underReplicatedLRPs     = "LRPsUnderReplicated"
+	oldestUnconvergedLRPAge = "OldestUnconvergedLRPAge"
+)
+
+// sendConvergence joins the desired LRPs with their actual LRPs. An instance
+// is missing unless an actual LRP at its index is running, and actual LRPs
+// beyond the desired instances or without a desired LRP at all are extra. A
+// desired LRP is converged if none of its instances are missing or extra.
+//
+// Receptor does not report when an LRP stopped being converged, so its age is
+// measured from the first cycle that found it unconverged.
+func (t *lrpInstrument) sendConvergence(desiredLRPs []receptor.DesiredLRPResponse, actualLRPs []receptor.ActualLRPResponse) {
+	type instances struct {
+		running map[int]struct{}
+		extra   int
+	}
+
+	desired := map[string]int{}
+	for _, lrp := range desiredLRPs {
+		desired[lrp.ProcessGuid] = lrp.Instances
+	}
+
+	actual := map[string]*instances{}
+	var orphaned int
+
+	for _, lrp := range actualLRPs {
+		desiredInstances, found := desired[lrp.ProcessGuid]
+		if !found {
+			orphaned++
+			continue
+		}
+
+		process, found := actual[lrp.ProcessGuid]
+		if !found {
+			process = &instances{running: map[int]struct{}{}}
+			actual[lrp.ProcessGuid] = process
+		}
+
+		switch {
+		case lrp.Index >= desiredInstances:
+			process.extra++
+		case lrp.State == receptor.ActualLRPStateRunning:
+			process.running[lrp.Index] = struct{}{}
+		}
+	}
+
+	now := t.clock.Now()
+	unconvergedSince := map[string]time.Time{}
+
+	missing := 0
+	extra := orphaned
+	converged := 0
+	underReplicated := 0
+
+	var oldest time.Duration
+
+	for processGuid, desiredInstances := range desired {
+		process, found := actual[processGuid]
+		if !found {
+			process = &instances{running: map[int]struct{}{}}
+		}
+
+		processMissing := desiredInstances - len(process.running)
+
+		missing += processMissing
+		extra += process.extra
+
+		if processMissing > 0 {
+			underReplicated++
+		}
+
+		if processMissing == 0 && process.extra == 0 {
+			converged++
+			continue
+		}
+
+		since, found := t.unconvergedSince[processGuid]
+		if !found {
+			since = now
+		}
+
+		unconvergedSince[processGuid] = since
+
+		if age := now.Sub(since); age > oldest {
+			oldest = age
+		}
+	}
+
+	t.unconvergedSince = unconvergedSince
+
+	t.sink.Gauge(missingLRPInstances, float64(missing), sink.Metric, nil)
+	t.sink.Gauge(extraLRPInstances, float64(extra), sink.Metric, nil)
+	t.sink.Gauge(convergedLRPs, float64(converged), sink.Metric, nil)
+	t.sink.Gauge(underReplicatedLRPs, float64(underReplicated), sink.Metric, nil)
+	t.sink.Duration(oldestUnconvergedLRPAge, oldest, nil)
+}
@@ -0,0 +1,115 @@
+package instruments
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/cloudfoundry-incubator/receptor"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
+)
+
+const (
+	actualLRPCrashCounts   = "ActualLRPCrashCounts"
+	crashLoopingActualLRPs = "CrashLoopingActualLRPs"
+	processCrashCount      = "ProcessCrashCount"
+
+	// only the worst crashing processes are reported on their own, to keep
+	// the number of series bounded
+	maxCrashingProcesses = 10
+)
+
+// upper bounds of the crash count histogram's buckets; like a Prometheus
+// histogram, each bucket counts every actual LRP up to its bound
+var crashCountBuckets = []int{0, 1, 2, 3, 5, 10, 25, 50}
+
+type processCrashes struct {
+	processGuid string
+	crashes     int
+	lastChange  int64
+}
+
+type crashStats struct {
+	crashCounts []int
+	looping     int
+	processes   map[string]*processCrashes
+}
+
+func newCrashStats() *crashStats {
+	return &crashStats{processes: map[string]*processCrashes{}}
+}
+
+// observe records an actual LRP's crashes. It is crash looping if it is
+// crashed, i.e. backing off before its next restart, after more than
+// threshold crashes.
+func (s *crashStats) observe(lrp receptor.ActualLRPResponse, threshold int) {
+	s.crashCounts = append(s.crashCounts, lrp.CrashCount)
+
+	if lrp.State == receptor.ActualLRPStateCrashed && lrp.CrashCount > threshold {
+		s.looping++
+	}
+
+	if lrp.CrashCount == 0 {
+		return
+	}
+
+	process, found := s.processes[lrp.ProcessGuid]
+	if !found {
+		process = &processCrashes{processGuid: lrp.ProcessGuid}
+		s.processes[lrp.ProcessGuid] = process
+	}
+
+	process.crashes += lrp.CrashCount
+	if lrp.Since > process.lastChange {
+		process.lastChange = lrp.Since
+	}
+}
+
+func (t *lrpInstrument) sendCrashStats(stats *crashStats) {
+	sort.Ints(stats.crashCounts)
+
+	for _, bound := range crashCountBuckets {
+		within := sort.SearchInts(stats.crashCounts, bound+1)
+		t.sink.Gauge(actualLRPCrashCounts, float64(within), sink.Metric, sink.Tags{"le": strconv.Itoa(bound)})
+	}
+
+	t.sink.Gauge(actualLRPCrashCounts, float64(len(stats.crashCounts)), sink.Metric, sink.Tags{"le": "+Inf"})
+	t.sink.Gauge(crashLoopingActualLRPs, float64(stats.looping), sink.Metric, nil)
+
+	processes := make([]*processCrashes, 0, len(stats.processes))
+	for _, process := range stats.processes {
+		processes = append(processes, process)
+	}
+
+	sort.Sort(byWorstCrashing(processes))
+
+	if len(processes) > maxCrashingProcesses {
+		processes = processes[:maxCrashingProcesses]
+	}
+
+	worst := stringSet{}
+	crashes := map[string]int{}
+	for _, process := range processes {
+		worst.add(process.processGuid)
+		crashes[process.processGuid] = process.crashes
+	}
+
+	for processGuid := range t.crashingProcesses.track(worst, true) {
+		t.sink.Gauge(processCrashCount, float64(crashes[processGuid]), sink.Metric, sink.Tags{"process_guid": processGuid})
+	}
+}
+
+// byWorstCrashing sorts the processes whose instances crashed the most first,
+// then the ones that changed state most recently
+type byWorstCrashing []*processCrashes
+
+func (p byWorstCrashing) Len() int      { return len(p) }
+func (p byWorstCrashing) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byWorstCrashing) Less(i, j int) bool {
+	if p[i].crashes != p[j].crashes {
+		return p[i].crashes > p[j].crashes
+	}
+	if p[i].lastChange != p[j].lastChange {
+		return p[i].lastChange > p[j].lastChange
+	}
+	return p[i].processGuid < p[j].processGuid
+}
@@ -1,64 +1,237 @@
 package instruments
 
 import (
+	"time"
+
 	"github.com/cloudfoundry-incubator/receptor"
-	"github.com/cloudfoundry-incubator/runtime-schema/metric"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
+	"github.com/pivotal-golang/clock"
 )
 
 const (
-	desiredLRPs         = metric.Metric("LRPsDesired")
-	startingLRPs        = metric.Metric("LRPsStarting")
-	runningLRPs         = metric.Metric("LRPsRunning")
-	crashedActualLRPs   = metric.Metric("CrashedActualLRPs")
-	crashingDesiredLRPs = metric.Metric("CrashingDesiredLRPs")
+	desiredLRPs         = "LRPsDesired"
+	unclaimedLRPs       = "LRPsUnclaimed"
+	startingLRPs        = "LRPsStarting"
+	runningLRPs         = "LRPsRunning"
+	evacuatingLRPs      = "LRPsEvacuating"
+	crashedActualLRPs   = "CrashedActualLRPs"
+	crashingDesiredLRPs = "CrashingDesiredLRPs"
+
+	unclaimedLRPsByPlacementError = "LRPsUnclaimedByPlacementError"
+
+	desiredLRPMemory    = "LRPsDesiredMemory"
+	desiredLRPDisk      = "LRPsDesiredDisk"
+	desiredLRPCPUWeight = "LRPsDesiredCPUWeight"
 )
 
+type lrpCounts struct {
+	desired          int
+	desiredResources resources
+	unclaimed        int
+	starting         int
+	running          int
+	evacuating       int
+	crashed          int
+	crashingDesireds map[string]struct{}
+}
+
+func newLRPCounts() *lrpCounts {
+	return &lrpCounts{crashingDesireds: map[string]struct{}{}}
+}
+
+// desire counts the instances of a desired LRP, and the resources they
+// require.
+func (c *lrpCounts) desire(lrp receptor.DesiredLRPResponse) {
+	c.desired += lrp.Instances
+	c.desiredResources.add(lrp.Instances, lrp.MemoryMB, lrp.DiskMB, lrp.CPUWeight)
+}
+
+// observe counts an actual LRP in its state. Evacuating actual LRPs are only
+// counted as evacuating, as another actual LRP is taking over their instance.
+func (c *lrpCounts) observe(lrp receptor.ActualLRPResponse) {
+	if lrp.Evacuating {
+		c.evacuating++
+		return
+	}
+
+	switch lrp.State {
+	case receptor.ActualLRPStateUnclaimed:
+		c.unclaimed++
+	case receptor.ActualLRPStateClaimed:
+		c.starting++
+	case receptor.ActualLRPStateRunning:
+		c.running++
+	case receptor.ActualLRPStateCrashed:
+		c.crashed++
+		c.crashingDesireds[lrp.ProcessGuid] = struct{}{}
+	}
+}
+
 type lrpInstrument struct {
-	receptorClient receptor.Client
+	source             Source
+	clock              clock.Clock
+	crashLoopThreshold int
+	failurePolicy      FailurePolicy
+	sink               sink.Sink
+
+	domains           tracker
+	crashingProcesses tracker
+	placementErrors   tracker
+	unconvergedSince  map[string]time.Time
 }
 
-func NewLRPInstrument(receptorClient receptor.Client) Instrument {
-	return &lrpInstrument{receptorClient: receptorClient}
+// NewLRPInstrument reports the desired and actual LRPs, how far apart they
+// are, and how badly they are crashing. Actual LRPs backing off after more
+// than crashLoopThreshold crashes are reported as crash looping.
+func NewLRPInstrument(source Source, clock clock.Clock, crashLoopThreshold int, failurePolicy FailurePolicy, metricSink sink.Sink) Instrument {
+	return &lrpInstrument{
+		source:             source,
+		clock:              clock,
+		crashLoopThreshold: crashLoopThreshold,
+		failurePolicy:      failurePolicy,
+		sink:               metricSink,
+	}
 }
 
-func (t *lrpInstrument) Send() {
-	desiredCount := 0
-	runningCount := 0
-	startingCount := 0
-	crashedCount := 0
+func (t *lrpInstrument) Send() error {
+	total := newLRPCounts()
+
+	domainCounts := map[string]*lrpCounts{}
+	countsFor := func(domain string) *lrpCounts {
+		counts, found := domainCounts[domain]
+		if !found {
+			counts = newLRPCounts()
+			domainCounts[domain] = counts
+		}
+		return counts
+	}
 
-	allDesiredLRPs, err := t.receptorClient.DesiredLRPs()
-	if err == nil {
+	allDesiredLRPs, desiredErr := t.source.DesiredLRPs()
+	if desiredErr == nil {
 		for _, lrp := range allDesiredLRPs {
-			desiredCount += lrp.Instances
+			total.desire(lrp)
+			countsFor(lrp.Domain).desire(lrp)
 		}
-	} else {
-		desiredCount = -1
 	}
 
-	crashingDesireds := map[string]struct{}{}
+	var instances []receptor.ActualLRPResponse
+	crashes := newCrashStats()
+	placementErrors := map[string]int{}
 
-	allActualLRPs, err := t.receptorClient.ActualLRPs()
-	if err == nil {
+	allActualLRPs, actualErr := t.source.ActualLRPs()
+	if actualErr == nil {
 		for _, lrp := range allActualLRPs {
-			switch lrp.State {
-			case receptor.ActualLRPStateClaimed:
-				startingCount++
-			case receptor.ActualLRPStateRunning:
-				runningCount++
-			case receptor.ActualLRPStateCrashed:
-				crashingDesireds[lrp.ProcessGuid] = struct{}{}
-				crashedCount++
+			total.observe(lrp)
+			countsFor(lrp.Domain).observe(lrp)
+
+			if lrp.Evacuating {
+				continue
+			}
+
+			instances = append(instances, lrp)
+			crashes.observe(lrp, t.crashLoopThreshold)
+
+			if lrp.State == receptor.ActualLRPStateUnclaimed {
+				placementErrors[lrp.PlacementError]++
 			}
 		}
+	}
+
+	if desiredErr == nil {
+		t.sendDesired(total, nil)
 	} else {
-		startingCount = -1
-		runningCount = -1
+		t.failurePolicy.sendFailed(t.sink, sink.Metric, desiredLRPs, desiredLRPCPUWeight)
+		t.failurePolicy.sendFailed(t.sink, sink.Mebibytes, desiredLRPMemory, desiredLRPDisk)
 	}
 
-	desiredLRPs.Send(desiredCount)
-	startingLRPs.Send(startingCount)
-	runningLRPs.Send(runningCount)
-	crashedActualLRPs.Send(crashedCount)
-	crashingDesiredLRPs.Send(len(crashingDesireds))
+	if actualErr == nil {
+		t.sink.Gauge(unclaimedLRPs, float64(total.unclaimed), sink.Metric, nil)
+		t.sink.Gauge(startingLRPs, float64(total.starting), sink.Metric, nil)
+		t.sink.Gauge(runningLRPs, float64(total.running), sink.Metric, nil)
+		t.sink.Gauge(evacuatingLRPs, float64(total.evacuating), sink.Metric, nil)
+		t.sink.Gauge(crashedActualLRPs, float64(total.crashed), sink.Metric, nil)
+		t.sink.Gauge(crashingDesiredLRPs, float64(len(total.crashingDesireds)), sink.Metric, nil)
+		t.sendPlacementErrors(placementErrors)
+		t.sendCrashStats(crashes)
+	} else {
+		t.failurePolicy.sendFailed(t.sink, sink.Metric, unclaimedLRPs, startingLRPs, runningLRPs, evacuatingLRPs, crashedActualLRPs, crashingDesiredLRPs, crashLoopingActualLRPs)
+	}
+
+	if desiredErr == nil && actualErr == nil {
+		t.sendConvergence(allDesiredLRPs, instances)
+	} else {
+		t.failurePolicy.sendFailed(t.sink, sink.Metric, missingLRPInstances, extraLRPInstances, convergedLRPs, underReplicatedLRPs)
+	}
+
+	t.sendDomainCounts(domainCounts, desiredErr == nil, actualErr == nil)
+
+	if desiredErr != nil {
+		return desiredErr
+	}
+
+	return actualErr
+}
+
+func (t *lrpInstrument) sendDesired(counts *lrpCounts, tags sink.Tags) {
+	t.sink.Gauge(desiredLRPs, float64(counts.desired), sink.Metric, tags)
+	t.sink.Gauge(desiredLRPMemory, float64(counts.desiredResources.memoryMB), sink.Mebibytes, tags)
+	t.sink.Gauge(desiredLRPDisk, float64(counts.desiredResources.diskMB), sink.Mebibytes, tags)
+	t.sink.Gauge(desiredLRPCPUWeight, float64(counts.desiredResources.cpuWeight), sink.Metric, tags)
+}
+
+// sendPlacementErrors reports why unclaimed actual LRPs could not be placed,
+// for the most common errors, counting the rest as "other". Those without a
+// placement error have not been attempted yet.
+func (t *lrpInstrument) sendPlacementErrors(counts map[string]int) {
+	normalized := map[string]int{}
+	for placementError, count := range counts {
+		tag := normalizeReason(placementError)
+		if tag == "" {
+			tag = "none"
+		}
+
+		normalized[tag] += count
+	}
+
+	bounded := boundReasons(normalized)
+
+	observed := stringSet{}
+	for placementError := range bounded {
+		observed.add(placementError)
+	}
+
+	for placementError := range t.placementErrors.track(observed, true) {
+		t.sink.Gauge(unclaimedLRPsByPlacementError, float64(bounded[placementError]), sink.Metric, sink.Tags{"placement_error": placementError})
+	}
+}
+
+func (t *lrpInstrument) sendDomainCounts(domainCounts map[string]*lrpCounts, desiredOK, actualOK bool) {
+	observed := stringSet{}
+	for domain := range domainCounts {
+		if domain != "" {
+			observed.add(domain)
+		}
+	}
+
+	for domain := range t.domains.track(observed, desiredOK && actualOK) {
+		counts, found := domainCounts[domain]
+		if !found {
+			counts = newLRPCounts()
+		}
+
+		tags := sink.Tags{"domain": domain}
+
+		if desiredOK {
+			t.sendDesired(counts, tags)
+		}
+
+		if actualOK {
+			t.sink.Gauge(unclaimedLRPs, float64(counts.unclaimed), sink.Metric, tags)
+			t.sink.Gauge(startingLRPs, float64(counts.starting), sink.Metric, tags)
+			t.sink.Gauge(runningLRPs, float64(counts.running), sink.Metric, tags)
+			t.sink.Gauge(evacuatingLRPs, float64(counts.evacuating), sink.Metric, tags)
+			t.sink.Gauge(crashedActualLRPs, float64(counts.crashed), sink.Metric, tags)
+			t.sink.Gauge(crashingDesiredLRPs, float64(len(counts.crashingDesireds)), sink.Metric, tags)
+		}
+	}
 }
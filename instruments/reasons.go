@@ -0,0 +1,83 @@
+package instruments
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// only the most common reasons are reported on their own, to keep the
+	// number of series bounded
+	maxReasons = 10
+
+	maxReasonLength = 64
+
+	otherReason = "other"
+)
+
+// the reasons end up in metric names, so they are reduced to words of
+// [a-z0-9] joined by underscores
+var reasonReplacements = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`[a-z]+://\S+`), " url "},
+	{regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`), " guid "},
+	{regexp.MustCompile(`[0-9]+`), " n "},
+	{regexp.MustCompile(`[^a-z0-9]+`), "_"},
+}
+
+// normalizeReason strips the details that make otherwise identical reasons
+// distinct, e.g. "Exited with status 2" and "Exited with status 137" both
+// become "exited_with_status_n". Nothing is left of a reason without words.
+func normalizeReason(reason string) string {
+	normalized := strings.ToLower(reason)
+	for _, r := range reasonReplacements {
+		normalized = r.pattern.ReplaceAllString(normalized, r.replacement)
+	}
+
+	if len(normalized) > maxReasonLength {
+		normalized = normalized[:maxReasonLength]
+	}
+
+	return strings.Trim(normalized, "_")
+}
+
+// boundReasons keeps the counts of the most common reasons, counting the rest
+// together as "other".
+func boundReasons(counts map[string]int) map[string]int {
+	reasons := make([]string, 0, len(counts))
+	for reason := range counts {
+		reasons = append(reasons, reason)
+	}
+
+	sort.Sort(byCountAndName{reasons, counts})
+
+	bounded := map[string]int{}
+	for i, reason := range reasons {
+		if i < maxReasons {
+			bounded[reason] = counts[reason]
+		} else {
+			bounded[otherReason] += counts[reason]
+		}
+	}
+
+	return bounded
+}
+
+// byCountAndName sorts the most common reasons first
+type byCountAndName struct {
+	reasons []string
+	counts  map[string]int
+}
+
+func (s byCountAndName) Len() int      { return len(s.reasons) }
+func (s byCountAndName) Swap(i, j int) { s.reasons[i], s.reasons[j] = s.reasons[j], s.reasons[i] }
+func (s byCountAndName) Less(i, j int) bool {
+	ci, cj := s.counts[s.reasons[i]], s.counts[s.reasons[j]]
+	if ci != cj {
+		return ci > cj
+	}
+	return s.reasons[i] < s.reasons[j]
+}
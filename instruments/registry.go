@@ -0,0 +1,200 @@
+package instruments
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-incubator/receptor"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
+	"github.com/cloudfoundry/storeadapter/etcdstoreadapter"
+	"github.com/pivotal-golang/clock"
+	"github.com/pivotal-golang/lager"
+)
+
+// the dependencies of the built-in instruments, as reported by the health
+// check
+const (
+	ReceptorDependency = "receptor"
+	ETCDDependency     = "etcd"
+)
+
+// Dependencies are what instruments are built from: what they share, and the
+// instrument's own config.
+type Dependencies struct {
+	Logger         lager.Logger
+	Clock          clock.Clock
+	ReceptorClient receptor.Client
+	Source         Source
+	FailurePolicy  FailurePolicy
+	ETCDOptions    *etcdstoreadapter.ETCDOptions
+
+	// the settings of the built-in instruments, which are the server's own
+	TaskAgeThresholds  []time.Duration
+	CrashLoopThreshold int
+	KnownStacks        []string
+	ETCDAPIVersion     ETCDAPIVersion
+
+	Config Config
+}
+
+// Factory builds an instrument that emits to the given sink.
+type Factory func(deps Dependencies, metricSink sink.Sink) (Instrument, error)
+
+// ConfigCheck checks an instrument's config without building the instrument,
+// so that a bad config is reported at startup along with every other problem.
+type ConfigCheck func(config Config) error
+
+// Registration is an instrument's factory, along with the name it is
+// configured and reported by, the dependency the health check holds
+// responsible when it fails, and the check of its config if it takes one.
+type Registration struct {
+	Name        string
+	Dependency  string
+	Factory     Factory
+	CheckConfig ConfigCheck
+}
+
+// Registry is a set of instruments for the notifier to run.
+type Registry struct {
+	lock          sync.Mutex
+	registrations []Registration
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// DefaultRegistry holds the built-in instruments, along with any registered
+// by other packages.
+var DefaultRegistry = NewRegistry()
+
+// Register makes an instrument that takes no config available to the
+// notifier, which runs every registered instrument unless configured
+// otherwise. It panics if the name is taken.
+func (r *Registry) Register(name string, dependency string, factory Factory) {
+	r.RegisterWithConfig(name, dependency, factory, nil)
+}
+
+// RegisterWithConfig registers an instrument whose config is checked by
+// check.
+func (r *Registry) RegisterWithConfig(name string, dependency string, factory Factory, check ConfigCheck) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, registration := range r.registrations {
+		if registration.Name == name {
+			panic(fmt.Sprintf("instrument registered twice: %q", name))
+		}
+	}
+
+	r.registrations = append(r.registrations, Registration{
+		Name:        name,
+		Dependency:  dependency,
+		Factory:     factory,
+		CheckConfig: check,
+	})
+}
+
+// Registered returns the registered instruments in the order they were
+// registered.
+func (r *Registry) Registered() []Registration {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return append([]Registration(nil), r.registrations...)
+}
+
+// IsRegistered reports whether an instrument has been registered by the name.
+func (r *Registry) IsRegistered(name string) bool {
+	_, found := r.lookup(name)
+	return found
+}
+
+// CheckConfig checks the named instrument's config. Instruments registered
+// without a check take none.
+func (r *Registry) CheckConfig(name string, config Config) error {
+	registration, found := r.lookup(name)
+	if !found {
+		return fmt.Errorf("unknown instrument: %q", name)
+	}
+
+	if registration.CheckConfig == nil {
+		if len(config) > 0 {
+			return fmt.Errorf("instrument %s takes no config", name)
+		}
+
+		return nil
+	}
+
+	err := registration.CheckConfig(config)
+	if err != nil {
+		return fmt.Errorf("invalid config of instrument %s: %s", name, err)
+	}
+
+	return nil
+}
+
+func (r *Registry) lookup(name string) (Registration, bool) {
+	for _, registration := range r.Registered() {
+		if registration.Name == name {
+			return registration, true
+		}
+	}
+
+	return Registration{}, false
+}
+
+// Register registers an instrument with the default registry. It is meant to
+// be called from an init function.
+func Register(name string, dependency string, factory Factory) {
+	DefaultRegistry.Register(name, dependency, factory)
+}
+
+// RegisterWithConfig registers an instrument whose config is checked by check
+// with the default registry.
+func RegisterWithConfig(name string, dependency string, factory Factory, check ConfigCheck) {
+	DefaultRegistry.RegisterWithConfig(name, dependency, factory, check)
+}
+
+// Registered returns the instruments in the default registry.
+func Registered() []Registration {
+	return DefaultRegistry.Registered()
+}
+
+// IsRegistered reports whether an instrument is in the default registry.
+func IsRegistered(name string) bool {
+	return DefaultRegistry.IsRegistered(name)
+}
+
+// CheckConfig checks the config of an instrument in the default registry.
+func CheckConfig(name string, config Config) error {
+	return DefaultRegistry.CheckConfig(name, config)
+}
+
+func init() {
+	Register("tasks", ReceptorDependency, func(deps Dependencies, metricSink sink.Sink) (Instrument, error) {
+		return NewTaskInstrument(deps.Logger, deps.Source, deps.Clock, deps.TaskAgeThresholds, deps.FailurePolicy, metricSink), nil
+	})
+	Register("lrps", ReceptorDependency, func(deps Dependencies, metricSink sink.Sink) (Instrument, error) {
+		return NewLRPInstrument(deps.Source, deps.Clock, deps.CrashLoopThreshold, deps.FailurePolicy, metricSink), nil
+	})
+	Register("domains", ReceptorDependency, func(deps Dependencies, metricSink sink.Sink) (Instrument, error) {
+		return NewDomainInstrument(deps.ReceptorClient, metricSink), nil
+	})
+	Register("cells", ReceptorDependency, func(deps Dependencies, metricSink sink.Sink) (Instrument, error) {
+		return NewCellInstrument(deps.Logger, deps.ReceptorClient, deps.Source, deps.FailurePolicy, metricSink), nil
+	})
+	Register("stacks", ReceptorDependency, func(deps Dependencies, metricSink sink.Sink) (Instrument, error) {
+		return NewStackInstrument(deps.Source, deps.KnownStacks, metricSink), nil
+	})
+	Register("routes", ReceptorDependency, func(deps Dependencies, metricSink sink.Sink) (Instrument, error) {
+		return NewRouteInstrument(deps.Logger, deps.Source, deps.FailurePolicy, metricSink), nil
+	})
+	Register("transitions", ReceptorDependency, func(deps Dependencies, metricSink sink.Sink) (Instrument, error) {
+		return NewTransitionInstrument(deps.Source, metricSink), nil
+	})
+	Register("etcd", ETCDDependency, func(deps Dependencies, metricSink sink.Sink) (Instrument, error) {
+		return NewETCDInstrument(deps.Logger, deps.ETCDOptions, deps.ETCDAPIVersion, deps.FailurePolicy, deps.Clock, metricSink)
+	})
+}
@@ -0,0 +1,14 @@
+package instruments
+
+// resources totals what a set of LRP instances or tasks require.
+type resources struct {
+	memoryMB  int
+	diskMB    int
+	cpuWeight uint
+}
+
+func (r *resources) add(instances int, memoryMB int, diskMB int, cpuWeight uint) {
+	r.memoryMB += instances * memoryMB
+	r.diskMB += instances * diskMB
+	r.cpuWeight += uint(instances) * cpuWeight
+}
@@ -0,0 +1,150 @@
+package instruments
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/receptor"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
+	"github.com/pivotal-golang/lager"
+)
+
+const (
+	routesRegistered       = "RoutesRegistered"
+	routesRunningInstances = "RoutesRunningInstances"
+	routesUnbacked         = "RoutesUnbacked"
+
+	cfRouterKey = "cf-router"
+)
+
+// cfRoute is a DesiredLRP's registration with the router: traffic for the
+// hostnames goes to the instances' container port.
+type cfRoute struct {
+	Hostnames []string `json:"hostnames"`
+	Port      uint16   `json:"port"`
+}
+
+type routeInstrument struct {
+	logger        lager.Logger
+	source        Source
+	failurePolicy FailurePolicy
+	sink          sink.Sink
+}
+
+// NewRouteInstrument reports the hostnames registered with the router, the
+// running instances behind them, and the hostnames without any, which the
+// router answers with a 502.
+func NewRouteInstrument(logger lager.Logger, source Source, failurePolicy FailurePolicy, metricSink sink.Sink) Instrument {
+	return &routeInstrument{
+		logger:        logger,
+		source:        source,
+		failurePolicy: failurePolicy,
+		sink:          metricSink,
+	}
+}
+
+func (t *routeInstrument) Send() error {
+	registered, backed, runningInstances, err := t.collect()
+	if err != nil {
+		t.failurePolicy.sendFailed(t.sink, sink.Metric, routesRegistered, routesRunningInstances, routesUnbacked)
+		return err
+	}
+
+	var unbacked int
+	for hostname := range registered {
+		if !backed.contains(hostname) {
+			unbacked++
+		}
+	}
+
+	t.sink.Gauge(routesRegistered, float64(len(registered)), sink.Metric, nil)
+	t.sink.Gauge(routesRunningInstances, float64(runningInstances), sink.Metric, nil)
+	t.sink.Gauge(routesUnbacked, float64(unbacked), sink.Metric, nil)
+
+	return nil
+}
+
+// collect finds the hostnames registered by the DesiredLRPs, and those that
+// a running instance exposing the route's port is behind.
+func (t *routeInstrument) collect() (registered stringSet, backed stringSet, runningInstances int, err error) {
+	desiredLRPs, err := t.source.DesiredLRPs()
+	if err != nil {
+		t.logger.Error("failed-to-get-desired-lrps", err)
+		return nil, nil, 0, err
+	}
+
+	actualLRPs, err := t.source.ActualLRPs()
+	if err != nil {
+		t.logger.Error("failed-to-get-actual-lrps", err)
+		return nil, nil, 0, err
+	}
+
+	registered = stringSet{}
+	routesByGuid := map[string][]cfRoute{}
+
+	for _, lrp := range desiredLRPs {
+		routes, err := decodeRoutes(lrp.Routes)
+		if err != nil {
+			t.logger.Error("failed-to-decode-routes", err, lager.Data{"process-guid": lrp.ProcessGuid})
+			continue
+		}
+
+		for _, route := range routes {
+			for _, hostname := range route.Hostnames {
+				registered.add(hostname)
+			}
+		}
+
+		routesByGuid[lrp.ProcessGuid] = routes
+	}
+
+	backed = stringSet{}
+
+	for _, lrp := range actualLRPs {
+		if lrp.State != receptor.ActualLRPStateRunning {
+			continue
+		}
+
+		var backing bool
+		for _, route := range routesByGuid[lrp.ProcessGuid] {
+			if !exposes(lrp, route.Port) {
+				continue
+			}
+
+			backing = true
+			for _, hostname := range route.Hostnames {
+				backed.add(hostname)
+			}
+		}
+
+		if backing {
+			runningInstances++
+		}
+	}
+
+	return registered, backed, runningInstances, nil
+}
+
+func decodeRoutes(routingInfo receptor.RoutingInfo) ([]cfRoute, error) {
+	raw := routingInfo[cfRouterKey]
+	if raw == nil {
+		return nil, nil
+	}
+
+	var routes []cfRoute
+	err := json.Unmarshal(*raw, &routes)
+	if err != nil {
+		return nil, err
+	}
+
+	return routes, nil
+}
+
+func exposes(lrp receptor.ActualLRPResponse, containerPort uint16) bool {
+	for _, mapping := range lrp.Ports {
+		if mapping.ContainerPort == containerPort {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,76 @@
+package instruments
+
+import (
+	"sync"
+
+	"github.com/cloudfoundry-incubator/receptor"
+)
+
+// SnapshotSource lists the tasks, desired LRPs and actual LRPs from its source
+// at most once each until it is expired, so that the instruments collecting
+// in the same cycle share one listing rather than each making their own. The
+// listings are shared, so they must not be modified.
+type SnapshotSource struct {
+	source Source
+
+	lock        sync.Mutex
+	tasks       *listing
+	desiredLRPs *listing
+	actualLRPs  *listing
+}
+
+// listing is made once, by the first caller; the others wait for it.
+type listing struct {
+	once  sync.Once
+	value interface{}
+	err   error
+}
+
+func NewSnapshotSource(source Source) *SnapshotSource {
+	return &SnapshotSource{source: source}
+}
+
+// Expire makes the next call of each kind list from the source again.
+func (s *SnapshotSource) Expire() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.tasks = nil
+	s.desiredLRPs = nil
+	s.actualLRPs = nil
+}
+
+func (s *SnapshotSource) Tasks() ([]receptor.TaskResponse, error) {
+	l := s.listing(&s.tasks)
+	l.once.Do(func() { l.value, l.err = s.source.Tasks() })
+
+	tasks, _ := l.value.([]receptor.TaskResponse)
+	return tasks, l.err
+}
+
+func (s *SnapshotSource) DesiredLRPs() ([]receptor.DesiredLRPResponse, error) {
+	l := s.listing(&s.desiredLRPs)
+	l.once.Do(func() { l.value, l.err = s.source.DesiredLRPs() })
+
+	lrps, _ := l.value.([]receptor.DesiredLRPResponse)
+	return lrps, l.err
+}
+
+func (s *SnapshotSource) ActualLRPs() ([]receptor.ActualLRPResponse, error) {
+	l := s.listing(&s.actualLRPs)
+	l.once.Do(func() { l.value, l.err = s.source.ActualLRPs() })
+
+	lrps, _ := l.value.([]receptor.ActualLRPResponse)
+	return lrps, l.err
+}
+
+func (s *SnapshotSource) listing(l **listing) *listing {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if *l == nil {
+		*l = &listing{}
+	}
+
+	return *l
+}
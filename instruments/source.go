@@ -0,0 +1,11 @@
+package instruments
+
+import "github.com/cloudfoundry-incubator/receptor"
+
+// Source is where the instruments read tasks and LRPs from: receptor itself,
+// or a model kept up to date by receptor's event stream.
+type Source interface {
+	Tasks() ([]receptor.TaskResponse, error)
+	DesiredLRPs() ([]receptor.DesiredLRPResponse, error)
+	ActualLRPs() ([]receptor.ActualLRPResponse, error)
+}
@@ -0,0 +1,92 @@
+package instruments
+
+import (
+	"strings"
+
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
+)
+
+const (
+	desiredLRPInstancesByStack = "LRPInstancesDesiredByStack"
+	tasksByStack               = "TasksByStack"
+
+	dockerStack = "docker"
+	otherStack  = "other"
+)
+
+type stackInstrument struct {
+	source      Source
+	knownStacks []string
+	sink        sink.Sink
+}
+
+// NewStackInstrument reports the desired LRP instances and the tasks on each
+// of the known stacks. Docker images are reported as "docker", and any other
+// rootfs as "other", so that the number of stacks reported stays bounded.
+func NewStackInstrument(source Source, knownStacks []string, metricSink sink.Sink) Instrument {
+	return &stackInstrument{
+		source:      source,
+		knownStacks: knownStacks,
+		sink:        metricSink,
+	}
+}
+
+func (t *stackInstrument) Send() error {
+	allDesiredLRPs, desiredErr := t.source.DesiredLRPs()
+	if desiredErr == nil {
+		instances := map[string]int{}
+		for _, lrp := range allDesiredLRPs {
+			instances[t.stack(lrp.RootFS)] += lrp.Instances
+		}
+
+		t.sendCounts(desiredLRPInstancesByStack, instances)
+	}
+
+	allTasks, tasksErr := t.source.Tasks()
+	if tasksErr == nil {
+		tasks := map[string]int{}
+		for _, task := range allTasks {
+			tasks[t.stack(task.RootFS)]++
+		}
+
+		t.sendCounts(tasksByStack, tasks)
+	}
+
+	if desiredErr != nil {
+		return desiredErr
+	}
+
+	return tasksErr
+}
+
+// sendCounts reports every stack, including those with nothing on them.
+func (t *stackInstrument) sendCounts(name string, counts map[string]int) {
+	for _, stack := range t.knownStacks {
+		t.sink.Gauge(name, float64(counts[stack]), sink.Metric, sink.Tags{"stack": stack})
+	}
+
+	t.sink.Gauge(name, float64(counts[dockerStack]), sink.Metric, sink.Tags{"stack": dockerStack})
+	t.sink.Gauge(name, float64(counts[otherStack]), sink.Metric, sink.Tags{"stack": otherStack})
+}
+
+// stack buckets a rootfs such as "preloaded:cflinuxfs2" or
+// "docker:///cloudfoundry/grace".
+func (t *stackInstrument) stack(rootFS string) string {
+	parts := strings.SplitN(rootFS, ":", 2)
+	if len(parts) != 2 {
+		return otherStack
+	}
+
+	switch parts[0] {
+	case "docker":
+		return dockerStack
+	case "preloaded":
+		for _, known := range t.knownStacks {
+			if parts[1] == known {
+				return known
+			}
+		}
+	}
+
+	return otherStack
+}
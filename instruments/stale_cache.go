@@ -0,0 +1,86 @@
+package instruments
+
+import (
+	"time"
+
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
+	"github.com/pivotal-golang/clock"
+)
+
+const instrumentStaleness = "InstrumentStaleness"
+
+type staleCache struct {
+	name         string
+	clock        clock.Clock
+	maxStaleness time.Duration
+	sink         sink.Sink
+
+	instrument Instrument
+	recorder   *sink.RecordingSink
+
+	lastGood   sink.Recording
+	lastGoodAt time.Time
+}
+
+// NewStaleCache builds an instrument emitting through a recording sink, and
+// re-emits the values it last collected successfully, tagged as stale, for up
+// to maxStaleness while it fails. Values that were still collected while it
+// failed are emitted as usual instead. Past maxStaleness, the failed
+// collection's values are emitted as usual.
+//
+// The age of the values is reported as InstrumentStaleness once the
+// instrument has succeeded.
+func NewStaleCache(name string, clock clock.Clock, maxStaleness time.Duration, metricSink sink.Sink, newInstrument func(sink.Sink) (Instrument, error)) (Instrument, error) {
+	recorder := sink.NewRecordingSink()
+
+	instrument, err := newInstrument(recorder)
+	if err != nil {
+		return nil, err
+	}
+
+	return &staleCache{
+		name:         name,
+		clock:        clock,
+		maxStaleness: maxStaleness,
+		sink:         metricSink,
+
+		instrument: instrument,
+		recorder:   recorder,
+	}, nil
+}
+
+func (c *staleCache) Send() error {
+	err := c.instrument.Send()
+	recording := c.recorder.Take()
+	now := c.clock.Now()
+
+	if err == nil {
+		c.lastGood = recording.Values()
+		c.lastGoodAt = now
+	}
+
+	if c.lastGoodAt.IsZero() {
+		recording.Replay(c.sink, nil)
+		return err
+	}
+
+	age := now.Sub(c.lastGoodAt)
+
+	switch {
+	case err == nil:
+		recording.Replay(c.sink, nil)
+	case age <= c.maxStaleness:
+		// whatever was collected despite the failure is reported as is, and
+		// the last good values stand in for the rest, including the values
+		// that were reported as failed
+		fresh := recording.WithoutGauges(failureSentinel)
+		fresh.Replay(c.sink, nil)
+		c.lastGood.Except(fresh).Replay(c.sink, sink.Tags{sink.StaleTag: "true"})
+	default:
+		recording.Replay(c.sink, nil)
+	}
+
+	c.sink.Duration(instrumentStaleness, age, sink.Tags{"instrument": c.name})
+
+	return err
+}
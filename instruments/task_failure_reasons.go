@@ -0,0 +1,33 @@
+package instruments
+
+import "github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
+
+const failedTasksByReason = "TasksFailedByReason"
+
+const unknownFailureReason = "unknown"
+
+// normalizeFailureReason reduces a task's failure reason to one shared by the
+// tasks that failed the same way.
+func normalizeFailureReason(reason string) string {
+	normalized := normalizeReason(reason)
+	if normalized == "" {
+		return unknownFailureReason
+	}
+
+	return normalized
+}
+
+// sendFailureReasons reports how many completed tasks failed for each of the
+// most common reasons, counting the rest as "other".
+func (t *taskInstrument) sendFailureReasons(counts map[string]int) {
+	bounded := boundReasons(counts)
+
+	observed := stringSet{}
+	for reason := range bounded {
+		observed.add(reason)
+	}
+
+	for reason := range t.failureReasons.track(observed, true) {
+		t.sink.Gauge(failedTasksByReason, float64(bounded[reason]), sink.Metric, sink.Tags{"reason": reason})
+	}
+}
@@ -1,59 +1,265 @@
 package instruments
 
 import (
+	"math"
+	"sort"
+	"time"
+
 	"github.com/cloudfoundry-incubator/receptor"
-	"github.com/cloudfoundry-incubator/runtime-schema/metric"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
+	"github.com/pivotal-golang/clock"
 	"github.com/pivotal-golang/lager"
 )
 
 const (
-	pendingTasks   = metric.Metric("TasksPending")
-	runningTasks   = metric.Metric("TasksRunning")
-	completedTasks = metric.Metric("TasksCompleted")
-	resolvingTasks = metric.Metric("TasksResolving")
+	pendingTasks   = "TasksPending"
+	runningTasks   = "TasksRunning"
+	completedTasks = "TasksCompleted"
+	resolvingTasks = "TasksResolving"
+
+	succeededTasks   = "TasksCompletedSucceeded"
+	failedTasks      = "TasksCompletedFailed"
+	taskFailureRatio = "TasksFailureRatio"
+
+	pendingTaskMemory    = "TasksPendingMemory"
+	pendingTaskDisk      = "TasksPendingDisk"
+	pendingTaskCPUWeight = "TasksPendingCPUWeight"
+	runningTaskMemory    = "TasksRunningMemory"
+	runningTaskDisk      = "TasksRunningDisk"
+	runningTaskCPUWeight = "TasksRunningCPUWeight"
 )
 
+// how long the tasks have been in each state, reported as percentiles, and
+// how many have been in it for longer than each of the configured thresholds
+var taskAgeMetrics = []struct {
+	state   string
+	age     string
+	overAge string
+}{
+	{receptor.TaskStatePending, "TasksPendingAge", "TasksPendingOverAge"},
+	{receptor.TaskStateRunning, "TasksRunningAge", "TasksRunningOverAge"},
+	{receptor.TaskStateCompleted, "TasksCompletedAge", "TasksCompletedOverAge"},
+	{receptor.TaskStateResolving, "TasksResolvingAge", "TasksResolvingOverAge"},
+}
+
+var taskAgePercentiles = []struct {
+	tag        string
+	percentile float64
+}{
+	{"p50", 50},
+	{"p90", 90},
+	{"p99", 99},
+	{"max", 100},
+}
+
+type taskCounts struct {
+	pending   int
+	running   int
+	completed int
+	resolving int
+
+	// completed tasks, by outcome
+	succeeded int
+	failed    int
+
+	// what the pending and running tasks require
+	pendingResources resources
+	runningResources resources
+}
+
+func (c *taskCounts) complete(task receptor.TaskResponse) {
+	c.completed++
+
+	if task.Failed {
+		c.failed++
+	} else {
+		c.succeeded++
+	}
+}
+
+// taskInState is when a task was first found in the state it is in.
+type taskInState struct {
+	state string
+	since time.Time
+}
+
 type taskInstrument struct {
-	logger         lager.Logger
-	receptorClient receptor.Client
+	logger        lager.Logger
+	source        Source
+	clock         clock.Clock
+	ageThresholds []time.Duration
+	failurePolicy FailurePolicy
+	sink          sink.Sink
+
+	domains        tracker
+	failureReasons tracker
+
+	// by task guid, as of the last successful collection
+	inStateSince map[string]taskInState
 }
 
-func NewTaskInstrument(logger lager.Logger, receptorClient receptor.Client) Instrument {
-	return &taskInstrument{logger: logger, receptorClient: receptorClient}
+// NewTaskInstrument reports the number of tasks in each state, along with how
+// long they have been in it and why completed tasks failed. Receptor only
+// reports when a task was created, which is when it became pending; the time
+// in any other state is measured from the first cycle that found the task in
+// it.
+func NewTaskInstrument(logger lager.Logger, source Source, clock clock.Clock, ageThresholds []time.Duration, failurePolicy FailurePolicy, metricSink sink.Sink) Instrument {
+	return &taskInstrument{
+		logger:        logger,
+		source:        source,
+		clock:         clock,
+		ageThresholds: ageThresholds,
+		failurePolicy: failurePolicy,
+		sink:          metricSink,
+	}
 }
 
-func (t *taskInstrument) Send() {
-	pendingCount := 0
-	runningCount := 0
-	completedCount := 0
-	resolvingCount := 0
+func (t *taskInstrument) Send() error {
+	var total taskCounts
+	domainCounts := map[string]*taskCounts{}
+
+	allTasks, err := t.source.Tasks()
+	if err != nil {
+		t.logger.Error("failed-to-get-tasks", err)
+		t.failurePolicy.sendFailed(t.sink, sink.Metric, pendingTasks, runningTasks, completedTasks, resolvingTasks, succeededTasks, failedTasks, taskFailureRatio, pendingTaskCPUWeight, runningTaskCPUWeight)
+		t.failurePolicy.sendFailed(t.sink, sink.Mebibytes, pendingTaskMemory, pendingTaskDisk, runningTaskMemory, runningTaskDisk)
+		return err
+	}
+
+	now := t.clock.Now()
+	ages := map[string][]time.Duration{}
+	failureReasons := map[string]int{}
+	inStateSince := make(map[string]taskInState, len(allTasks))
 
-	allTasks, err := t.receptorClient.Tasks()
+	for _, task := range allTasks {
+		inState, found := t.inStateSince[task.TaskGuid]
+		if !found || inState.state != task.State {
+			inState = taskInState{state: task.State, since: now}
 
-	if err == nil {
-		for _, task := range allTasks {
-			switch task.State {
-			case receptor.TaskStatePending:
-				pendingCount++
-			case receptor.TaskStateRunning:
-				runningCount++
-			case receptor.TaskStateCompleted:
-				completedCount++
-			case receptor.TaskStateResolving:
-				resolvingCount++
+			if task.State == receptor.TaskStatePending {
+				inState.since = time.Unix(0, task.CreatedAt)
 			}
 		}
-	} else {
-		t.logger.Error("failed-to-get-tasks", err)
 
-		pendingCount = -1
-		runningCount = -1
-		completedCount = -1
-		resolvingCount = -1
+		inStateSince[task.TaskGuid] = inState
+		ages[task.State] = append(ages[task.State], now.Sub(inState.since))
+
+		counts, found := domainCounts[task.Domain]
+		if !found {
+			counts = &taskCounts{}
+			domainCounts[task.Domain] = counts
+		}
+
+		switch task.State {
+		case receptor.TaskStatePending:
+			total.pending++
+			total.pendingResources.add(1, task.MemoryMB, task.DiskMB, task.CPUWeight)
+			counts.pending++
+			counts.pendingResources.add(1, task.MemoryMB, task.DiskMB, task.CPUWeight)
+		case receptor.TaskStateRunning:
+			total.running++
+			total.runningResources.add(1, task.MemoryMB, task.DiskMB, task.CPUWeight)
+			counts.running++
+			counts.runningResources.add(1, task.MemoryMB, task.DiskMB, task.CPUWeight)
+		case receptor.TaskStateCompleted:
+			total.complete(task)
+			counts.complete(task)
+
+			if task.Failed {
+				failureReasons[normalizeFailureReason(task.FailureReason)]++
+			}
+		case receptor.TaskStateResolving:
+			total.resolving++
+			counts.resolving++
+		}
+	}
+
+	t.inStateSince = inStateSince
+
+	t.sendCounts(total, nil)
+	t.sendAges(ages)
+	t.sendFailureReasons(failureReasons)
+
+	observed := stringSet{}
+	for domain := range domainCounts {
+		if domain != "" {
+			observed.add(domain)
+		}
+	}
+
+	for domain := range t.domains.track(observed, true) {
+		counts, found := domainCounts[domain]
+		if !found {
+			counts = &taskCounts{}
+		}
+
+		t.sendCounts(*counts, sink.Tags{"domain": domain})
+	}
+
+	return nil
+}
+
+func (t *taskInstrument) sendCounts(counts taskCounts, tags sink.Tags) {
+	t.sink.Gauge(pendingTasks, float64(counts.pending), sink.Metric, tags)
+	t.sink.Gauge(runningTasks, float64(counts.running), sink.Metric, tags)
+	t.sink.Gauge(completedTasks, float64(counts.completed), sink.Metric, tags)
+	t.sink.Gauge(succeededTasks, float64(counts.succeeded), sink.Metric, tags)
+	t.sink.Gauge(failedTasks, float64(counts.failed), sink.Metric, tags)
+	t.sink.Gauge(resolvingTasks, float64(counts.resolving), sink.Metric, tags)
+
+	t.sink.Gauge(pendingTaskMemory, float64(counts.pendingResources.memoryMB), sink.Mebibytes, tags)
+	t.sink.Gauge(pendingTaskDisk, float64(counts.pendingResources.diskMB), sink.Mebibytes, tags)
+	t.sink.Gauge(pendingTaskCPUWeight, float64(counts.pendingResources.cpuWeight), sink.Metric, tags)
+	t.sink.Gauge(runningTaskMemory, float64(counts.runningResources.memoryMB), sink.Mebibytes, tags)
+	t.sink.Gauge(runningTaskDisk, float64(counts.runningResources.diskMB), sink.Mebibytes, tags)
+	t.sink.Gauge(runningTaskCPUWeight, float64(counts.runningResources.cpuWeight), sink.Metric, tags)
+
+	var failureRatio float64
+	if counts.completed > 0 {
+		failureRatio = float64(counts.failed) / float64(counts.completed)
 	}
 
-	pendingTasks.Send(pendingCount)
-	runningTasks.Send(runningCount)
-	completedTasks.Send(completedCount)
-	resolvingTasks.Send(resolvingCount)
+	t.sink.Gauge(taskFailureRatio, failureRatio, sink.Metric, tags)
 }
+
+func (t *taskInstrument) sendAges(ages map[string][]time.Duration) {
+	for _, metric := range taskAgeMetrics {
+		stateAges := ages[metric.state]
+		sort.Sort(durations(stateAges))
+
+		for _, p := range taskAgePercentiles {
+			t.sink.Duration(metric.age, percentile(stateAges, p.percentile), sink.Tags{"percentile": p.tag})
+		}
+
+		for _, threshold := range t.ageThresholds {
+			// ages are sorted, so every task from the first one over the
+			// threshold on is over it
+			over := len(stateAges) - sort.Search(len(stateAges), func(i int) bool {
+				return stateAges[i] > threshold
+			})
+
+			t.sink.Gauge(metric.overAge, float64(over), sink.Metric, sink.Tags{"threshold": threshold.String()})
+		}
+	}
+}
+
+// percentile picks the nearest-ranked of the sorted durations, or zero if
+// there are none.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
@@ -0,0 +1,40 @@
+package instruments
+
+type stringSet map[string]struct{}
+
+func (s stringSet) add(value string) {
+	s[value] = struct{}{}
+}
+
+func (s stringSet) contains(value string) bool {
+	_, found := s[value]
+	return found
+}
+
+// tracker remembers which keys (e.g. domains or cells) were reported on the
+// previous cycle, so that a key which has since disappeared is reported as
+// empty once instead of keeping its last value forever.
+type tracker struct {
+	reported stringSet
+}
+
+// track returns the observed keys along with every key reported on the
+// previous cycle. If the observation was incomplete (e.g. a request failed),
+// the previously reported keys are kept around for the next cycle.
+func (t *tracker) track(observed stringSet, complete bool) stringSet {
+	all := stringSet{}
+	for key := range observed {
+		all.add(key)
+	}
+	for key := range t.reported {
+		all.add(key)
+	}
+
+	if complete {
+		t.reported = observed
+	} else {
+		t.reported = all
+	}
+
+	return all
+}
@@ -0,0 +1,161 @@
+package instruments
+
+import (
+	"strings"
+
+	"github.com/cloudfoundry-incubator/receptor"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
+)
+
+const (
+	taskStateTransitions      = "TaskStateTransitions"
+	actualLRPStateTransitions = "ActualLRPStateTransitions"
+	actualLRPCrashes          = "ActualLRPCrashes"
+
+	// the state of a task or actual LRP before it was first seen, or after it
+	// was last seen
+	absentState = "none"
+)
+
+type transition struct {
+	from string
+	to   string
+}
+
+type actualLRPSnapshot struct {
+	state      string
+	crashCount int
+}
+
+type instanceKey struct {
+	processGuid string
+	index       int
+}
+
+type transitionInstrument struct {
+	source Source
+	sink   sink.Sink
+
+	// the states as of the last successful collection; nil until then
+	taskStates      map[string]string
+	actualLRPStates map[instanceKey]actualLRPSnapshot
+}
+
+// NewTransitionInstrument counts the tasks and actual LRPs that changed state
+// between one collection and the next, and the crashes in between. Changes
+// that are undone before the next collection go unnoticed, other than
+// crashes.
+func NewTransitionInstrument(source Source, metricSink sink.Sink) Instrument {
+	return &transitionInstrument{source: source, sink: metricSink}
+}
+
+func (t *transitionInstrument) Send() error {
+	tasks, tasksErr := t.source.Tasks()
+	if tasksErr == nil {
+		t.sendTaskTransitions(tasks)
+	}
+
+	actualLRPs, actualErr := t.source.ActualLRPs()
+	if actualErr == nil {
+		t.sendActualLRPTransitions(actualLRPs)
+	}
+
+	if tasksErr != nil {
+		return tasksErr
+	}
+
+	return actualErr
+}
+
+func (t *transitionInstrument) sendTaskTransitions(tasks []receptor.TaskResponse) {
+	states := make(map[string]string, len(tasks))
+	for _, task := range tasks {
+		states[task.TaskGuid] = task.State
+	}
+
+	if t.taskStates != nil {
+		transitions := map[transition]int{}
+
+		for guid, state := range states {
+			previous, found := t.taskStates[guid]
+			if !found {
+				previous = absentState
+			}
+
+			if previous != state {
+				transitions[transition{previous, state}]++
+			}
+		}
+
+		for guid, previous := range t.taskStates {
+			if _, found := states[guid]; !found {
+				transitions[transition{previous, absentState}]++
+			}
+		}
+
+		t.sendTransitions(taskStateTransitions, transitions)
+	}
+
+	t.taskStates = states
+}
+
+// sendActualLRPTransitions follows each instance by its process guid and
+// index; evacuating actual LRPs are left out, as another actual LRP is taking
+// over their instance.
+func (t *transitionInstrument) sendActualLRPTransitions(actualLRPs []receptor.ActualLRPResponse) {
+	snapshots := make(map[instanceKey]actualLRPSnapshot, len(actualLRPs))
+	for _, lrp := range actualLRPs {
+		if lrp.Evacuating {
+			continue
+		}
+
+		snapshots[instanceKey{lrp.ProcessGuid, lrp.Index}] = actualLRPSnapshot{lrp.State, lrp.CrashCount}
+	}
+
+	if t.actualLRPStates != nil {
+		transitions := map[transition]int{}
+		var crashes int
+
+		for key, snapshot := range snapshots {
+			previous, found := t.actualLRPStates[key]
+			if !found {
+				previous = actualLRPSnapshot{state: absentState}
+			}
+
+			if previous.state != snapshot.state {
+				transitions[transition{previous.state, snapshot.state}]++
+			}
+
+			// the crash count starts over once an instance has run for a
+			// while, in which case it counts the crashes since
+			if snapshot.crashCount > previous.crashCount {
+				crashes += snapshot.crashCount - previous.crashCount
+			} else if snapshot.crashCount < previous.crashCount {
+				crashes += snapshot.crashCount
+			}
+		}
+
+		for key, previous := range t.actualLRPStates {
+			if _, found := snapshots[key]; !found {
+				transitions[transition{previous.state, absentState}]++
+			}
+		}
+
+		t.sendTransitions(actualLRPStateTransitions, transitions)
+
+		if crashes > 0 {
+			t.sink.Counter(actualLRPCrashes, uint64(crashes), nil)
+		}
+	}
+
+	t.actualLRPStates = snapshots
+}
+
+func (t *transitionInstrument) sendTransitions(name string, transitions map[transition]int) {
+	for tr, count := range transitions {
+		t.sink.Counter(name, uint64(count), sink.Tags{
+			"from": strings.ToLower(tr.from),
+			"to":   strings.ToLower(tr.to),
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/instruments"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
+	"github.com/pivotal-golang/lager"
+)
+
+const (
+	instrumentDuration           = "InstrumentDuration"
+	instrumentTimeouts           = "InstrumentTimeouts"
+	instrumentCollectionFailures = "InstrumentCollectionFailures"
+	instrumentLastSuccess        = "InstrumentLastSuccess"
+)
+
+var errInstrumentTimedOut = errors.New("instrument timed out")
+
+// collector runs a single instrument, making sure that an instrument which
+// is still busy from a previous cycle is not started a second time.
+type collector struct {
+	name       string
+	dependency string
+	interval   time.Duration
+	instrument instruments.Instrument
+	busy       chan struct{}
+
+	lock        sync.Mutex
+	lastSuccess time.Time
+}
+
+func newCollector(name string, dependency string, interval time.Duration, instrument instruments.Instrument) *collector {
+	return &collector{
+		name:       name,
+		dependency: dependency,
+		interval:   interval,
+		instrument: instrument,
+		busy:       make(chan struct{}, 1),
+	}
+}
+
+// collectAll runs every collector concurrently and returns once each of them
+// has either finished or exceeded the instrument timeout. A zero timeout
+// waits for every instrument to finish.
+func (notifier PeriodicMetronNotifier) collectAll(collectors []*collector) {
+	wg := new(sync.WaitGroup)
+
+	for _, c := range collectors {
+		wg.Add(1)
+
+		go func(c *collector) {
+			defer wg.Done()
+			notifier.collect(c)
+		}(c)
+	}
+
+	wg.Wait()
+}
+
+func (notifier PeriodicMetronNotifier) collect(c *collector) {
+	logger := notifier.Dependencies.Logger.Session("collect", lager.Data{"instrument": c.name})
+	tags := sink.Tags{"instrument": c.name}
+
+	select {
+	case c.busy <- struct{}{}:
+	default:
+		logger.Info("skipped-still-running")
+		return
+	}
+
+	finished := make(chan struct{})
+
+	// a run that timed out is recorded as such, even if it finishes later
+	var recorded sync.Once
+	record := func(err error) {
+		recorded.Do(func() { notifier.record(c, err) })
+	}
+
+	go func() {
+		defer func() { <-c.busy }()
+
+		startedAt := notifier.Dependencies.Clock.Now()
+		err := c.instrument.Send()
+
+		// instruments that timed out still report how long they actually took
+		notifier.Sink.Duration(instrumentDuration, notifier.Dependencies.Clock.Now().Sub(startedAt), tags)
+		record(err)
+
+		close(finished)
+	}()
+
+	if notifier.InstrumentTimeout <= 0 {
+		<-finished
+		return
+	}
+
+	timer := notifier.Dependencies.Clock.NewTimer(notifier.InstrumentTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-finished:
+	case <-timer.C():
+		logger.Error("timed-out", errInstrumentTimedOut, lager.Data{"timeout": notifier.InstrumentTimeout.String()})
+		notifier.Sink.Counter(instrumentTimeouts, 1, tags)
+		record(errInstrumentTimedOut)
+	}
+}
+
+// record reports the outcome of a collection, so that failures can be told
+// apart from values that are legitimately zero. The last success is reported
+// as a Unix timestamp in seconds on every collection once there has been one,
+// so that it is still there to alert on while the instrument keeps failing.
+func (notifier PeriodicMetronNotifier) record(c *collector, err error) {
+	tags := sink.Tags{"instrument": c.name}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err == nil {
+		c.lastSuccess = notifier.Dependencies.Clock.Now()
+		notifier.HealthCheck.InstrumentSucceeded(c.name, c.dependency, c.interval)
+	} else {
+		notifier.Sink.Counter(instrumentCollectionFailures, 1, tags)
+		notifier.HealthCheck.InstrumentFailed(c.name, c.dependency, c.interval, err)
+	}
+
+	if !c.lastSuccess.IsZero() {
+		notifier.Sink.Gauge(instrumentLastSuccess, float64(c.lastSuccess.Unix()), sink.Metric, tags)
+	}
+}
@@ -2,74 +2,116 @@ package metrics
 
 import (
 	"os"
+	"sync"
 	"time"
 
-	"github.com/cloudfoundry-incubator/receptor"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/health_check"
 	"github.com/cloudfoundry-incubator/runtime-metrics-server/instruments"
-	"github.com/cloudfoundry-incubator/runtime-schema/metric"
-	"github.com/cloudfoundry/storeadapter/etcdstoreadapter"
-	"github.com/pivotal-golang/clock"
-	"github.com/pivotal-golang/lager"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
 )
 
-const metricsReportingDuration = metric.Duration("MetricsReportingDuration")
+const metricsReportingDuration = "MetricsReportingDuration"
+
+// Settings are how often the notifier reports, and how it runs its
+// instruments.
+type Settings struct {
+	Interval          time.Duration
+	Instruments       map[string]InstrumentSettings
+	InstrumentTimeout time.Duration
+	MaxStaleness      time.Duration
+}
 
 type PeriodicMetronNotifier struct {
-	Interval       time.Duration
-	ETCDOptions    *etcdstoreadapter.ETCDOptions
-	Logger         lager.Logger
-	Clock          clock.Clock
-	ReceptorClient receptor.Client
+	Settings
+	Registry     *instruments.Registry
+	Dependencies instruments.Dependencies
+	Sink         sink.Sink
+	HealthCheck  *health_check.HealthCheck
 }
 
-func NewPeriodicMetronNotifier(logger lager.Logger,
-	interval time.Duration,
-	etcdOptions *etcdstoreadapter.ETCDOptions,
-	clock clock.Clock,
-	receptorClient receptor.Client) *PeriodicMetronNotifier {
+// NewPeriodicMetronNotifier builds a notifier that runs the instruments in
+// the default registry, each built from deps along with its own config.
+func NewPeriodicMetronNotifier(settings Settings, deps instruments.Dependencies, metricSink sink.Sink, healthCheck *health_check.HealthCheck) *PeriodicMetronNotifier {
 	return &PeriodicMetronNotifier{
-		Interval:       interval,
-		ETCDOptions:    etcdOptions,
-		Logger:         logger,
-		Clock:          clock,
-		ReceptorClient: receptorClient,
+		Settings:     settings,
+		Registry:     instruments.DefaultRegistry,
+		Dependencies: deps,
+		Sink:         metricSink,
+		HealthCheck:  healthCheck,
 	}
 }
 
 func (notifier PeriodicMetronNotifier) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
 
-	etcdInstrument, err := instruments.NewETCDInstrument(notifier.Logger, notifier.ETCDOptions)
+	// the instruments of a cycle share one listing of each kind from the
+	// source
+	source := instruments.NewSnapshotSource(notifier.Dependencies.Source)
+
+	collectors, err := notifier.collectors(source)
 	if err != nil {
 		return err
 	}
 
-	ticker := notifier.Clock.NewTicker(notifier.Interval)
-	defer ticker.Stop()
+	done := make(chan struct{})
+	wg := new(sync.WaitGroup)
+
+	// the tickers are all set up before becoming ready, so that the first
+	// ticks are never missed
+	for _, s := range notifier.schedules(collectors) {
+		wg.Add(1)
+		go notifier.run(s, source, notifier.Dependencies.Clock.NewTicker(s.interval), done, wg)
+	}
 
 	close(ready)
 
-	tasksInstrument := instruments.NewTaskInstrument(notifier.Logger, notifier.ReceptorClient)
-	lrpsInstrument := instruments.NewLRPInstrument(notifier.ReceptorClient)
-	domainInstrument := instruments.NewDomainInstrument(notifier.ReceptorClient)
+	<-signals
+
+	close(done)
+	wg.Wait()
+
+	return nil
+}
 
-	for {
-		select {
-		case <-ticker.C():
-			startedAt := notifier.Clock.Now()
+// collectors builds every registered instrument that is not disabled.
+func (notifier PeriodicMetronNotifier) collectors(source instruments.Source) ([]*collector, error) {
+	err := notifier.validateInstrumentSettings()
+	if err != nil {
+		return nil, err
+	}
 
-			tasksInstrument.Send()
-			lrpsInstrument.Send()
-			domainInstrument.Send()
-			etcdInstrument.Send()
+	registrations := notifier.Registry.Registered()
 
-			finishedAt := notifier.Clock.Now()
+	collectors := make([]*collector, 0, len(registrations))
+	for _, r := range registrations {
+		settings := notifier.Instruments[r.Name]
+		if settings.Disabled {
+			continue
+		}
 
-			metricsReportingDuration.Send(finishedAt.Sub(startedAt))
+		deps := notifier.Dependencies
+		deps.Source = source
+		deps.Config = settings.Config
 
-		case <-signals:
-			return nil
+		factory := r.Factory
+		instrument, err := notifier.newInstrument(r.Name, func(metricSink sink.Sink) (instruments.Instrument, error) {
+			return factory(deps, metricSink)
+		})
+		if err != nil {
+			return nil, err
 		}
+
+		collectors = append(collectors, newCollector(r.Name, r.Dependency, notifier.interval(r.Name), instrument))
 	}
 
-	return nil
+	return collectors, nil
+}
+
+// newInstrument builds an instrument, caching its last good values if
+// configured to.
+func (notifier PeriodicMetronNotifier) newInstrument(name string, build func(sink.Sink) (instruments.Instrument, error)) (instruments.Instrument, error) {
+	if notifier.MaxStaleness <= 0 {
+		return build(notifier.Sink)
+	}
+
+	return instruments.NewStaleCache(name, notifier.Dependencies.Clock, notifier.MaxStaleness, notifier.Sink, build)
 }
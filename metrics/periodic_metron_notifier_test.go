@@ -1,14 +1,20 @@
 package metrics_test
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/cloudfoundry-incubator/receptor"
 	"github.com/cloudfoundry-incubator/receptor/fake_receptor"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/health_check"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/instruments"
 	"github.com/cloudfoundry-incubator/runtime-metrics-server/metrics"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
 	"github.com/cloudfoundry/dropsonde/metric_sender/fake"
 	dropsonde_metrics "github.com/cloudfoundry/dropsonde/metrics"
 	"github.com/cloudfoundry/storeadapter/etcdstoreadapter"
@@ -24,38 +30,119 @@ import (
 // a bit of grace time for eventuallys
 const aBit = 50 * time.Millisecond
 
+func cfRoutes(routes string) receptor.RoutingInfo {
+	raw := json.RawMessage(routes)
+	return receptor.RoutingInfo{"cf-router": &raw}
+}
+
+// an instrument registered the way an in-house one would be, from outside
+// the instruments package
+type customInstrument struct {
+	value float64
+	sink  sink.Sink
+}
+
+func (i customInstrument) Send() error {
+	i.sink.Gauge("CustomMetric", i.value, sink.Metric, nil)
+	return nil
+}
+
+type customConfig struct {
+	Value float64 `json:"value"`
+}
+
+func decodeCustomConfig(config instruments.Config) (customConfig, error) {
+	decoded := customConfig{Value: 42}
+	err := config.Decode(&decoded)
+	return decoded, err
+}
+
+func newCustomInstrument(deps instruments.Dependencies, metricSink sink.Sink) (instruments.Instrument, error) {
+	config, err := decodeCustomConfig(deps.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	return customInstrument{config.Value, metricSink}, nil
+}
+
+func checkCustomConfig(config instruments.Config) error {
+	_, err := decodeCustomConfig(config)
+	return err
+}
+
 var _ = Describe("PeriodicMetronNotifier", func() {
 	var (
 		sender *fake.FakeMetricSender
 
 		receptorClient *fake_receptor.FakeClient
 
-		etcdOptions    etcdstoreadapter.ETCDOptions
-		reportInterval time.Duration
-		fakeClock      *fakeclock.FakeClock
+		etcdOptions        etcdstoreadapter.ETCDOptions
+		etcdAPIVersion     instruments.ETCDAPIVersion
+		reportInterval     time.Duration
+		instrumentSettings map[string]metrics.InstrumentSettings
+		instrumentTimeout  time.Duration
+		failurePolicy      instruments.FailurePolicy
+		maxStaleness       time.Duration
+		taskAgeThresholds  []time.Duration
+		crashLoopThreshold int
+		knownStacks        []string
+		registry           *instruments.Registry
+		fakeClock          *fakeclock.FakeClock
+		healthCheck        *health_check.HealthCheck
 
 		pmn ifrit.Process
 	)
 
 	BeforeEach(func() {
 		reportInterval = 100 * time.Millisecond
+		instrumentSettings = nil
+		instrumentTimeout = 2 * time.Hour
+		failurePolicy = instruments.SentinelOnFailure
+		maxStaleness = 0
+		taskAgeThresholds = nil
+		crashLoopThreshold = 3
+		knownStacks = []string{"cflinuxfs2"}
+		etcdAPIVersion = instruments.ETCDAPIAuto
+		registry = instruments.DefaultRegistry
 
 		fakeClock = fakeclock.NewFakeClock(time.Unix(123, 456))
 
 		receptorClient = new(fake_receptor.FakeClient)
 
+		healthCheck = health_check.New(fakeClock, 10*time.Hour)
+
 		sender = fake.NewFakeMetricSender()
 		dropsonde_metrics.Initialize(sender, nil)
 	})
 
 	JustBeforeEach(func() {
-		pmn = ifrit.Invoke(metrics.NewPeriodicMetronNotifier(
-			lagertest.NewTestLogger("test"),
-			reportInterval,
-			&etcdOptions,
-			fakeClock,
-			receptorClient,
-		))
+		notifier := metrics.NewPeriodicMetronNotifier(
+			metrics.Settings{
+				Interval:          reportInterval,
+				Instruments:       instrumentSettings,
+				InstrumentTimeout: instrumentTimeout,
+				MaxStaleness:      maxStaleness,
+			},
+			instruments.Dependencies{
+				Logger:         lagertest.NewTestLogger("test"),
+				Clock:          fakeClock,
+				ReceptorClient: receptorClient,
+				Source:         receptorClient,
+				FailurePolicy:  failurePolicy,
+				ETCDOptions:    &etcdOptions,
+
+				TaskAgeThresholds:  taskAgeThresholds,
+				CrashLoopThreshold: crashLoopThreshold,
+				KnownStacks:        knownStacks,
+				ETCDAPIVersion:     etcdAPIVersion,
+			},
+			sink.NewDropsondeSink(),
+			healthCheck,
+		)
+		notifier.Registry = registry
+
+		pmn = ifrit.Invoke(notifier)
 	})
 
 	AfterEach(func() {
@@ -95,6 +182,10 @@ var _ = Describe("PeriodicMetronNotifier", func() {
 
 			Context("when the etcd server gives valid JSON", func() {
 				BeforeEach(func() {
+					for _, server := range []*ghttp.Server{etcd1, etcd2, etcd3} {
+						server.RouteToHandler("GET", "/version", ghttp.RespondWith(200, `{"etcdserver":"2.1.1","etcdcluster":"2.1.0"}`))
+					}
+
 					etcd1.RouteToHandler("GET", "/v2/stats/self", ghttp.RespondWith(200, `
             {
               "name": "node1",
@@ -115,10 +206,6 @@ var _ = Describe("PeriodicMetronNotifier", func() {
             }
 	        `))
 
-					etcd1.RouteToHandler("GET", "/v2/stats/leader", func(w http.ResponseWriter, r *http.Request) {
-						http.Redirect(w, r, etcd2.URL(), 302)
-					})
-
 					etcd2.RouteToHandler("GET", "/v2/stats/self", ghttp.RespondWith(200, `
             {
               "name": "node2",
@@ -218,9 +305,6 @@ var _ = Describe("PeriodicMetronNotifier", func() {
             }
 	        `))
 
-					etcd3.RouteToHandler("GET", "/v2/stats/leader", func(w http.ResponseWriter, r *http.Request) {
-						http.Redirect(w, r, etcd2.URL(), 302)
-					})
 				})
 
 				It("should emit them", func() {
@@ -273,45 +357,513 @@ var _ = Describe("PeriodicMetronNotifier", func() {
 						Unit:  "Metric",
 					}))
 				})
+
+				It("should emit the leader the members agree on and each member's role", func() {
+					Eventually(func() fake.Metric {
+						return sender.GetValue("ETCDLeaderID.node2-id")
+					}).Should(Equal(fake.Metric{
+						Value: 1,
+						Unit:  "Metric",
+					}))
+
+					Eventually(func() fake.Metric {
+						return sender.GetValue("ETCDLeaderDisagreement")
+					}).Should(Equal(fake.Metric{
+						Value: 0,
+						Unit:  "Metric",
+					}))
+
+					Eventually(func() fake.Metric {
+						return sender.GetValue("ETCDMemberIsLeader.node2-id")
+					}).Should(Equal(fake.Metric{
+						Value: 1,
+						Unit:  "Metric",
+					}))
+
+					Eventually(func() fake.Metric {
+						return sender.GetValue("ETCDMemberIsLeader.node1-id")
+					}).Should(Equal(fake.Metric{
+						Value: 0,
+						Unit:  "Metric",
+					}))
+
+					Eventually(func() fake.Metric {
+						return sender.GetValue("ETCDMemberIsLeader.node3-id")
+					}).Should(Equal(fake.Metric{
+						Value: 0,
+						Unit:  "Metric",
+					}))
+				})
+
+				Context("when the members disagree about the leader", func() {
+					BeforeEach(func() {
+						etcd3.RouteToHandler("GET", "/v2/stats/self", ghttp.RespondWith(200, `{
+							"name": "node3",
+							"id": "node3-id",
+							"state": "StateLeader",
+							"leaderInfo": {"leader": "node3-id"}
+						}`))
+					})
+
+					It("should emit the disagreement and not pick a leader", func() {
+						Eventually(func() fake.Metric {
+							return sender.GetValue("ETCDLeaderDisagreement")
+						}).Should(Equal(fake.Metric{
+							Value: 1,
+							Unit:  "Metric",
+						}))
+
+						Expect(sender.GetValue("ETCDMemberIsLeader.node3-id").Value).To(Equal(float64(1)))
+						Expect(sender.GetValue("ETCDLeader")).To(BeZero())
+						Expect(sender.GetValue("ETCDRaftTerm")).To(BeZero())
+					})
+				})
+
+				Context("when a member does not know of a leader", func() {
+					BeforeEach(func() {
+						etcd3.RouteToHandler("GET", "/v2/stats/self", ghttp.RespondWith(200, `{
+							"name": "node3",
+							"id": "node3-id",
+							"state": "StateFollower",
+							"leaderInfo": {"leader": ""}
+						}`))
+					})
+
+					It("should emit the disagreement", func() {
+						Eventually(func() fake.Metric {
+							return sender.GetValue("ETCDLeaderDisagreement")
+						}).Should(Equal(fake.Metric{
+							Value: 1,
+							Unit:  "Metric",
+						}))
+					})
+				})
+
+				Context("when the leader's stats cannot be read", func() {
+					BeforeEach(func() {
+						etcd2.RouteToHandler("GET", "/v2/stats/store", ghttp.RespondWith(500, ""))
+					})
+
+					It("reports -1 for the raft term and watchers", func() {
+						Eventually(func() fake.Metric {
+							return sender.GetValue("ETCDWatchers")
+						}).Should(Equal(fake.Metric{
+							Value: -1,
+							Unit:  "Metric",
+						}))
+
+						Expect(sender.GetValue("ETCDRaftTerm").Value).To(Equal(float64(-1)))
+						Expect(sender.GetValue("ETCDLeader").Value).To(Equal(float64(1)))
+					})
+				})
+
+				Context("when the leader changes", func() {
+					var leaderChanged int32
+
+					BeforeEach(func() {
+						leaderChanged = 0
+
+						selfStats := func(id string) http.HandlerFunc {
+							return func(w http.ResponseWriter, r *http.Request) {
+								state, leader := "StateFollower", "node2-id"
+								if atomic.LoadInt32(&leaderChanged) == 1 {
+									leader = "node1-id"
+								}
+
+								if leader == id {
+									state = "StateLeader"
+								}
+
+								fmt.Fprintf(w, `{"id": "%s", "state": "%s", "leaderInfo": {"leader": "%s"}}`, id, state, leader)
+							}
+						}
+
+						etcd1.RouteToHandler("GET", "/v2/stats/self", selfStats("node1-id"))
+						etcd2.RouteToHandler("GET", "/v2/stats/self", selfStats("node2-id"))
+						etcd3.RouteToHandler("GET", "/v2/stats/self", selfStats("node3-id"))
+
+						etcd1.RouteToHandler("GET", "/v2/stats/leader", ghttp.RespondWith(200, `{"leader": "node1-id"}`))
+						etcd1.RouteToHandler("GET", "/v2/stats/store", ghttp.RespondWith(200, `{"watchers": 1}`))
+						etcd1.RouteToHandler("GET", "/v2/keys", func(w http.ResponseWriter, r *http.Request) {
+							w.Header().Set("X-Raft-Term", "124")
+						})
+					})
+
+					It("should report the new leader and stop reporting the previous one", func() {
+						Eventually(func() fake.Metric {
+							return sender.GetValue("ETCDLeaderID.node2-id")
+						}).Should(Equal(fake.Metric{
+							Value: 1,
+							Unit:  "Metric",
+						}))
+
+						atomic.StoreInt32(&leaderChanged, 1)
+
+						Eventually(func() fake.Metric {
+							fakeClock.Increment(reportInterval)
+							return sender.GetValue("ETCDLeaderID.node1-id")
+						}).Should(Equal(fake.Metric{
+							Value: 1,
+							Unit:  "Metric",
+						}))
+
+						Eventually(func() float64 {
+							return sender.GetValue("ETCDLeaderID.node2-id").Value
+						}).Should(BeZero())
+
+						Eventually(func() float64 {
+							return sender.GetValue("ETCDLeader").Value
+						}).Should(BeZero())
+
+						Eventually(func() float64 {
+							return sender.GetValue("ETCDRaftTerm").Value
+						}).Should(Equal(float64(124)))
+					})
+
+					It("should count the leader changes and elections since the previous cycle", func() {
+						Eventually(func() fake.Metric {
+							return sender.GetValue("ETCDLeaderID.node2-id")
+						}).Should(Equal(fake.Metric{
+							Value: 1,
+							Unit:  "Metric",
+						}))
+
+						Eventually(func() float64 {
+							fakeClock.Increment(reportInterval)
+							return sender.GetValue("ETCDTimeSinceLeaderChange").Value
+						}).Should(BeNumerically(">=", reportInterval))
+
+						Expect(sender.GetCounter("ETCDLeaderChanges")).To(BeZero())
+
+						atomic.StoreInt32(&leaderChanged, 1)
+
+						Eventually(func() uint64 {
+							fakeClock.Increment(reportInterval)
+							return sender.GetCounter("ETCDRaftTermIncrements")
+						}).Should(Equal(uint64(1)))
+
+						Eventually(func() uint64 {
+							return sender.GetCounter("ETCDLeaderChanges")
+						}).Should(Equal(uint64(1)))
+
+						Consistently(func() uint64 {
+							fakeClock.Increment(reportInterval)
+							return sender.GetCounter("ETCDLeaderChanges")
+						}, 5*aBit, aBit).Should(Equal(uint64(1)))
+					})
+				})
+
+				It("should emit follower latencies reported by the leader", func() {
+					Eventually(func() fake.Metric {
+						return sender.GetValue("ETCDFollowerLatencyCurrent.node1-id")
+					}).Should(Equal(fake.Metric{
+						Value: 153507,
+						Unit:  "nanos",
+					}))
+
+					Eventually(func() fake.Metric {
+						return sender.GetValue("ETCDFollowerLatencyMinimum.node3-id")
+					}).Should(Equal(fake.Metric{
+						Value: 73,
+						Unit:  "nanos",
+					}))
+
+					Eventually(func() fake.Metric {
+						return sender.GetValue("ETCDFollowerLatencyMaximum.node3-id")
+					}).Should(Equal(fake.Metric{
+						Value: 16432439,
+						Unit:  "nanos",
+					}))
+
+					Eventually(func() fake.Metric {
+						return sender.GetValue("ETCDFollowerMaxLatency")
+					}).Should(Equal(fake.Metric{
+						Value: 153507,
+						Unit:  "nanos",
+					}))
+				})
+
+				Context("when the followers' append counts change between reports", func() {
+					var leaderStatsRequests int32
+
+					BeforeEach(func() {
+						leaderStatsRequests = 0
+
+						etcd2.RouteToHandler("GET", "/v2/stats/leader", func(w http.ResponseWriter, r *http.Request) {
+							n := atomic.AddInt32(&leaderStatsRequests, 1)
+
+							fmt.Fprintf(w, `{
+								"leader": "node2-id",
+								"followers": {
+									"node1-id": {"counts": {"fail": %d, "success": %d}},
+									"node3-id": {"counts": {"fail": 4, "success": 214969}}
+								}
+							}`, 4+n, 215000+9*n)
+						})
+					})
+
+					It("should emit the appends since the last report and the failure rate", func() {
+						Eventually(func() fake.Metric {
+							fakeClock.Increment(reportInterval)
+							return sender.GetValue("ETCDFollowerAppendFailureRate")
+						}).Should(Equal(fake.Metric{
+							Value: 0.1,
+							Unit:  "Metric",
+						}))
+
+						Expect(sender.GetCounter("ETCDFollowerAppendFailures.node1-id")).To(BeNumerically(">=", 1))
+						Expect(sender.GetCounter("ETCDFollowerAppendSuccesses.node1-id")).To(BeNumerically(">=", 9))
+						Expect(sender.GetCounter("ETCDFollowerAppendFailures.node3-id")).To(BeZero())
+					})
+				})
+			})
+
+			Context("when no member can be reached", func() {
+				BeforeEach(func() {
+					for _, server := range []*ghttp.Server{etcd1, etcd2, etcd3} {
+						server.RouteToHandler("GET", "/version", ghttp.RespondWith(200, `{"etcdserver":"2.1.1","etcdcluster":"2.1.0"}`))
+						server.RouteToHandler("GET", "/v2/stats/self", ghttp.RespondWith(500, ""))
+					}
+				})
+
+				It("reports -1 for the cluster-wide metrics", func() {
+					Eventually(func() fake.Metric {
+						return sender.GetValue("ETCDWatchers")
+					}).Should(Equal(fake.Metric{
+						Value: -1,
+						Unit:  "Metric",
+					}))
+
+					Expect(sender.GetValue("ETCDLeader").Value).To(Equal(float64(-1)))
+					Expect(sender.GetValue("ETCDLeaderDisagreement").Value).To(Equal(float64(-1)))
+					Expect(sender.GetValue("ETCDRaftTerm").Value).To(Equal(float64(-1)))
+
+					Expect(sender.GetValue("ETCDSentBandwidthRate")).To(Equal(fake.Metric{Value: -1, Unit: "B/s"}))
+					Expect(sender.GetValue("ETCDReceivedBandwidthRate")).To(Equal(fake.Metric{Value: -1, Unit: "B/s"}))
+					Expect(sender.GetValue("ETCDSentRequestRate")).To(Equal(fake.Metric{Value: -1, Unit: "Req/s"}))
+					Expect(sender.GetValue("ETCDReceivedRequestRate")).To(Equal(fake.Metric{Value: -1, Unit: "Req/s"}))
+				})
+
+				Context("when configured to skip failed values", func() {
+					BeforeEach(func() {
+						failurePolicy = instruments.SkipOnFailure
+					})
+
+					It("reports nothing for them", func() {
+						Eventually(func() string {
+							return healthCheck.Status().Instruments["etcd"].LastError
+						}).ShouldNot(BeEmpty())
+
+						Expect(sender.GetValue("ETCDLeader")).To(BeZero())
+						Expect(sender.GetValue("ETCDSentBandwidthRate")).To(BeZero())
+					})
+				})
+			})
+
+			Context("when the etcd cluster speaks v3", func() {
+				status := func(memberID string) http.HandlerFunc {
+					return ghttp.RespondWith(200, `{
+						"header": {"cluster_id": "14841639068965178418", "member_id": "`+memberID+`", "revision": "72", "raft_term": "8"},
+						"version": "3.4.3",
+						"db_size": "2097152",
+						"leader": "2",
+						"raft_index": "4567",
+						"raft_term": "8"
+					}`)
+				}
+
+				memberList := func() http.HandlerFunc {
+					return ghttp.RespondWith(200, `{
+						"header": {"cluster_id": "14841639068965178418", "member_id": "2", "raft_term": "8"},
+						"members": [
+							{"ID": "1", "name": "node1", "clientURLs": ["`+etcd1.URL()+`"]},
+							{"ID": "2", "name": "node2", "clientURLs": ["`+etcd2.URL()+`"]},
+							{"ID": "3", "name": "node3", "clientURLs": ["`+etcd3.URL()+`"]}
+						]
+					}`)
+				}
+
+				BeforeEach(func() {
+					for _, server := range []*ghttp.Server{etcd1, etcd2, etcd3} {
+						server.RouteToHandler("GET", "/version", ghttp.RespondWith(200, `{"etcdserver":"3.4.3","etcdcluster":"3.4.0"}`))
+					}
+
+					metricsPage := func(watchers int) http.HandlerFunc {
+						return ghttp.RespondWith(200, fmt.Sprintf(`# HELP etcd_debugging_mvcc_watcher_total Total number of watchers.
+# TYPE etcd_debugging_mvcc_watcher_total gauge
+etcd_debugging_mvcc_watcher_total %d
+# HELP etcd_debugging_mvcc_watch_stream_total Total number of watch streams.
+# TYPE etcd_debugging_mvcc_watch_stream_total gauge
+etcd_debugging_mvcc_watch_stream_total 3
+`, watchers))
+					}
+
+					etcd1.RouteToHandler("POST", "/v3/maintenance/status", status("1"))
+					etcd2.RouteToHandler("POST", "/v3/maintenance/status", status("2"))
+					etcd3.RouteToHandler("POST", "/v3/maintenance/status", status("3"))
+
+					etcd1.RouteToHandler("GET", "/metrics", metricsPage(4))
+					etcd2.RouteToHandler("GET", "/metrics", metricsPage(5))
+					etcd3.RouteToHandler("GET", "/metrics", metricsPage(6))
+
+					etcd2.RouteToHandler("POST", "/v3/cluster/member/list", memberList())
+				})
+
+				It("should emit the metrics v3 reports under the v2 names", func() {
+					Eventually(func() fake.Metric {
+						return sender.GetValue("ETCDLeader")
+					}).Should(Equal(fake.Metric{
+						Value: 1,
+						Unit:  "Metric",
+					}))
+
+					Eventually(func() fake.Metric {
+						return sender.GetValue("ETCDLeaderID.2")
+					}).Should(Equal(fake.Metric{
+						Value: 1,
+						Unit:  "Metric",
+					}))
+
+					Eventually(func() fake.Metric {
+						return sender.GetValue("ETCDRaftTerm")
+					}).Should(Equal(fake.Metric{
+						Value: 8,
+						Unit:  "Metric",
+					}))
+
+					Eventually(func() fake.Metric {
+						return sender.GetValue("ETCDFollowers")
+					}).Should(Equal(fake.Metric{
+						Value: 2,
+						Unit:  "Metric",
+					}))
+
+					Eventually(func() fake.Metric {
+						return sender.GetValue("ETCDWatchers")
+					}).Should(Equal(fake.Metric{
+						Value: 15,
+						Unit:  "Metric",
+					}))
+				})
+
+				It("should emit the raft index and database size", func() {
+					Eventually(func() fake.Metric {
+						return sender.GetValue("ETCDRaftIndex")
+					}).Should(Equal(fake.Metric{
+						Value: 4567,
+						Unit:  "Metric",
+					}))
+
+					Eventually(func() fake.Metric {
+						return sender.GetValue("ETCDDBSize")
+					}).Should(Equal(fake.Metric{
+						Value: 2097152,
+						Unit:  "B",
+					}))
+				})
+
+				Context("when the cluster serves the gateway under an earlier prefix", func() {
+					BeforeEach(func() {
+						for i, server := range []*ghttp.Server{etcd1, etcd2, etcd3} {
+							server.RouteToHandler("GET", "/version", ghttp.RespondWith(200, `{"etcdserver":"3.3.10","etcdcluster":"3.3.0"}`))
+							server.RouteToHandler("POST", "/v3/maintenance/status", ghttp.RespondWith(404, ""))
+							server.RouteToHandler("POST", "/v3beta/maintenance/status", status(fmt.Sprint(i+1)))
+						}
+
+						etcd2.RouteToHandler("POST", "/v3/cluster/member/list", ghttp.RespondWith(404, ""))
+						etcd2.RouteToHandler("POST", "/v3beta/cluster/member/list", memberList())
+					})
+
+					It("finds the gateway under that prefix", func() {
+						Eventually(func() fake.Metric {
+							return sender.GetValue("ETCDFollowers")
+						}).Should(Equal(fake.Metric{
+							Value: 2,
+							Unit:  "Metric",
+						}))
+
+						Expect(sender.GetValue("ETCDLeader").Value).To(Equal(float64(1)))
+					})
+				})
+
+				Context("when no member answers", func() {
+					BeforeEach(func() {
+						for _, server := range []*ghttp.Server{etcd1, etcd2, etcd3} {
+							server.RouteToHandler("POST", "/v3/maintenance/status", ghttp.RespondWith(500, ""))
+						}
+					})
+
+					It("reports -1 for the cluster-wide metrics", func() {
+						Eventually(func() fake.Metric {
+							return sender.GetValue("ETCDDBSize")
+						}).Should(Equal(fake.Metric{
+							Value: -1,
+							Unit:  "B",
+						}))
+
+						Expect(sender.GetValue("ETCDLeader").Value).To(Equal(float64(-1)))
+						Expect(sender.GetValue("ETCDRaftTerm").Value).To(Equal(float64(-1)))
+						Expect(sender.GetValue("ETCDRaftIndex").Value).To(Equal(float64(-1)))
+						Expect(sender.GetValue("ETCDFollowers").Value).To(Equal(float64(-1)))
+						Expect(sender.GetValue("ETCDWatchers").Value).To(Equal(float64(-1)))
+					})
+				})
+
+				It("should not emit the v2-only rates", func() {
+					Eventually(func() fake.Metric {
+						return sender.GetValue("ETCDWatchers")
+					}).ShouldNot(BeZero())
+
+					Expect(sender.GetValue("ETCDSentRequestRate")).To(BeZero())
+				})
 			})
 		})
 
 		Context("when the read from the store succeeds", func() {
 			BeforeEach(func() {
 				receptorClient.TasksReturns([]receptor.TaskResponse{
-					receptor.TaskResponse{State: receptor.TaskStatePending},
-					receptor.TaskResponse{State: receptor.TaskStatePending},
-					receptor.TaskResponse{State: receptor.TaskStatePending},
+					receptor.TaskResponse{Domain: "domain", RootFS: "preloaded:cflinuxfs2", MemoryMB: 128, DiskMB: 256, CPUWeight: 1, State: receptor.TaskStatePending},
+					receptor.TaskResponse{Domain: "domain", RootFS: "preloaded:cflinuxfs2", MemoryMB: 128, DiskMB: 256, CPUWeight: 1, State: receptor.TaskStatePending},
+					receptor.TaskResponse{Domain: "other-domain", RootFS: "preloaded:lucid64", MemoryMB: 512, DiskMB: 1024, CPUWeight: 2, State: receptor.TaskStatePending},
 
-					receptor.TaskResponse{State: receptor.TaskStateRunning},
+					receptor.TaskResponse{Domain: "other-domain", RootFS: "docker:///cloudfoundry/grace", CellID: "cell-1", MemoryMB: 64, DiskMB: 32, CPUWeight: 5, State: receptor.TaskStateRunning},
 
-					receptor.TaskResponse{State: receptor.TaskStateCompleted},
-					receptor.TaskResponse{State: receptor.TaskStateCompleted},
-					receptor.TaskResponse{State: receptor.TaskStateCompleted},
-					receptor.TaskResponse{State: receptor.TaskStateCompleted},
+					receptor.TaskResponse{Domain: "domain", State: receptor.TaskStateCompleted},
+					receptor.TaskResponse{Domain: "domain", State: receptor.TaskStateCompleted, Failed: true, FailureReason: "Exited with status 2"},
+					receptor.TaskResponse{Domain: "domain", State: receptor.TaskStateCompleted, Failed: true, FailureReason: "exited with status 137"},
+					receptor.TaskResponse{Domain: "domain", State: receptor.TaskStateCompleted, Failed: true},
 
-					receptor.TaskResponse{State: receptor.TaskStateResolving},
-					receptor.TaskResponse{State: receptor.TaskStateResolving},
+					receptor.TaskResponse{Domain: "domain", State: receptor.TaskStateResolving},
+					receptor.TaskResponse{Domain: "other-domain", State: receptor.TaskStateResolving},
 				}, nil)
 
 				receptorClient.DomainsReturns([]string{"some-domain", "some-other-domain"}, nil)
 
 				receptorClient.DesiredLRPsReturns([]receptor.DesiredLRPResponse{
-					{ProcessGuid: "desired-1", Instances: 2},
-					{ProcessGuid: "desired-2", Instances: 3},
+					{ProcessGuid: "desired-1", Domain: "domain", RootFS: "preloaded:cflinuxfs2", Instances: 2, MemoryMB: 128, DiskMB: 256, CPUWeight: 10,
+						Routes: cfRoutes(`[{"hostnames": ["app-1.example.com", "www.example.com"], "port": 8080}]`)},
+					{ProcessGuid: "desired-2", Domain: "other-domain", RootFS: "docker:///cloudfoundry/grace", Instances: 3, MemoryMB: 256, DiskMB: 512, CPUWeight: 20,
+						Routes: cfRoutes(`[{"hostnames": ["app-2.example.com"], "port": 8080}]`)},
 				}, nil)
 
-				receptorClient.ActualLRPsStub = func() ([]receptor.ActualLRPResponse, error) {
+				receptorClient.ActualLRPsReturns([]receptor.ActualLRPResponse{
+					{ProcessGuid: "desired-1", Index: 0, Domain: "domain", CellID: "cell-1", State: receptor.ActualLRPStateRunning, Ports: []receptor.PortMapping{{ContainerPort: 8080, HostPort: 61001}}},
+					{ProcessGuid: "desired-1", Index: 1, Domain: "domain", CellID: "cell-2", State: receptor.ActualLRPStateRunning, Ports: []receptor.PortMapping{{ContainerPort: 8080, HostPort: 61002}}},
+					{ProcessGuid: "desired-2", Index: 1, Domain: "other-domain", CellID: "cell-2", State: receptor.ActualLRPStateClaimed},
+					{ProcessGuid: "desired-3", Index: 0, Domain: "domain", CellID: "cell-1", State: receptor.ActualLRPStateRunning},
+					{ProcessGuid: "desired-3", Index: 1, Domain: "domain", State: receptor.ActualLRPStateCrashed, CrashCount: 2},
+					{ProcessGuid: "desired-3", Index: 2, Domain: "domain", State: receptor.ActualLRPStateCrashed, CrashCount: 7},
+					{ProcessGuid: "desired-4", Index: 0, Domain: "domain", State: receptor.ActualLRPStateCrashed, CrashCount: 30},
+				}, nil)
+
+				receptorClient.CellsStub = func() ([]receptor.CellResponse, error) {
 					fakeClock.Increment(time.Hour)
 
-					return []receptor.ActualLRPResponse{
-						{ProcessGuid: "desired-1", Index: 0, Domain: "domain", State: receptor.ActualLRPStateRunning},
-						{ProcessGuid: "desired-1", Index: 1, Domain: "domain", State: receptor.ActualLRPStateRunning},
-						{ProcessGuid: "desired-2", Index: 1, Domain: "domain", State: receptor.ActualLRPStateClaimed},
-						{ProcessGuid: "desired-3", Index: 0, Domain: "domain", State: receptor.ActualLRPStateRunning},
-						{ProcessGuid: "desired-3", Index: 1, Domain: "domain", State: receptor.ActualLRPStateCrashed},
-						{ProcessGuid: "desired-3", Index: 2, Domain: "domain", State: receptor.ActualLRPStateCrashed},
-						{ProcessGuid: "desired-4", Index: 0, Domain: "domain", State: receptor.ActualLRPStateCrashed},
+					capacity := receptor.CellCapacity{MemoryMB: 1024, DiskMB: 2048, Containers: 10}
+
+					return []receptor.CellResponse{
+						{CellID: "cell-1", Zone: "z1", Capacity: capacity},
+						{CellID: "cell-2", Zone: "z2", Capacity: capacity},
 					}, nil
 				}
 			})
@@ -325,6 +877,44 @@ var _ = Describe("PeriodicMetronNotifier", func() {
 				}))
 			})
 
+			It("reports how long each instrument took", func() {
+				Eventually(func() fake.Metric {
+					return sender.GetValue("InstrumentDuration.cells")
+				}).Should(Equal(fake.Metric{
+					Value: float64(1 * time.Hour),
+					Unit:  "nanos",
+				}))
+
+				Eventually(func() string {
+					return sender.GetValue("InstrumentDuration.tasks").Unit
+				}).Should(Equal("nanos"))
+			})
+
+			It("records that the instruments and receptor are healthy", func() {
+				Eventually(func() time.Time {
+					return healthCheck.Status().Instruments["cells"].LastSuccess
+				}).ShouldNot(BeZero())
+
+				status := healthCheck.Status()
+				Expect(status.Instruments["tasks"].LastSuccess).NotTo(BeZero())
+				Expect(status.Instruments["lrps"].LastSuccess).NotTo(BeZero())
+				Expect(status.Instruments["domains"].LastSuccess).NotTo(BeZero())
+				Expect(status.Dependencies["receptor"].Reachable).To(BeTrue())
+			})
+
+			It("reports when each instrument last succeeded", func() {
+				Eventually(func() string {
+					return sender.GetValue("InstrumentLastSuccess.tasks").Unit
+				}).Should(Equal("Metric"))
+
+				// every cycle moves the clock on by an hour, so the last success
+				// can be a cycle behind the clock
+				lastSuccess := sender.GetValue("InstrumentLastSuccess.tasks").Value
+				Expect(lastSuccess).To(BeNumerically(">=", time.Unix(123, 456).Unix()))
+				Expect(lastSuccess).To(BeNumerically("<=", fakeClock.Now().Unix()))
+				Expect(sender.GetCounter("InstrumentCollectionFailures.tasks")).To(BeZero())
+			})
+
 			It("reports that the store's domains are fresh", func() {
 				Eventually(func() fake.Metric {
 					return sender.GetValue("Domain.some-domain")
@@ -371,70 +961,741 @@ var _ = Describe("PeriodicMetronNotifier", func() {
 				}))
 			})
 
-			It("emits desired LRP metrics", func() {
-				Eventually(func() fake.Metric {
-					return sender.GetValue("LRPsDesired")
-				}).Should(Equal(fake.Metric{
-					Value: 5,
-					Unit:  "Metric",
-				}))
-
+			It("reports the outcome of completed tasks", func() {
 				Eventually(func() fake.Metric {
-					return sender.GetValue("LRPsStarting")
+					return sender.GetValue("TasksFailureRatio")
 				}).Should(Equal(fake.Metric{
-					Value: 1,
+					Value: 0.75,
 					Unit:  "Metric",
 				}))
 
-				Eventually(func() fake.Metric {
-					return sender.GetValue("LRPsRunning")
-				}).Should(Equal(fake.Metric{
-					Value: 3,
-					Unit:  "Metric",
-				}))
+				Expect(sender.GetValue("TasksCompletedSucceeded").Value).To(Equal(float64(1)))
+				Expect(sender.GetValue("TasksCompletedFailed").Value).To(Equal(float64(3)))
 
-				Eventually(func() fake.Metric {
-					return sender.GetValue("CrashedActualLRPs")
-				}).Should(Equal(fake.Metric{
-					Value: 3,
-					Unit:  "Metric",
-				}))
+				Eventually(func() float64 {
+					return sender.GetValue("TasksFailureRatio.other-domain").Value
+				}).Should(BeZero())
+			})
 
+			It("reports why tasks failed, without the details", func() {
 				Eventually(func() fake.Metric {
-					return sender.GetValue("CrashingDesiredLRPs")
+					return sender.GetValue("TasksFailedByReason.exited_with_status_n")
 				}).Should(Equal(fake.Metric{
 					Value: 2,
 					Unit:  "Metric",
 				}))
-			})
-		})
 
-		Context("when the store cannot be reached", func() {
-			BeforeEach(func() {
-				receptorClient.TasksReturns(nil, errors.New("Doesn't work"))
-				receptorClient.DesiredLRPsReturns(nil, errors.New("no."))
-				receptorClient.ActualLRPsReturns(nil, errors.New("pushed to master"))
+				Eventually(func() float64 {
+					return sender.GetValue("TasksFailedByReason.unknown").Value
+				}).Should(Equal(float64(1)))
 			})
 
-			It("reports -1 for all task metrics", func() {
-				Eventually(func() fake.Metric {
-					return sender.GetValue("TasksPending")
-				}).Should(Equal(fake.Metric{
-					Value: -1,
-					Unit:  "Metric",
-				}))
+			Context("when tasks fail for many different reasons", func() {
+				BeforeEach(func() {
+					var tasks []receptor.TaskResponse
+					for i := 0; i < 15; i++ {
+						for j := 0; j <= i; j++ {
+							tasks = append(tasks, receptor.TaskResponse{
+								State:         receptor.TaskStateCompleted,
+								Failed:        true,
+								FailureReason: fmt.Sprintf("reason %c", 'a'+i),
+							})
+						}
+					}
 
-				Eventually(func() fake.Metric {
-					return sender.GetValue("TasksRunning")
-				}).Should(Equal(fake.Metric{
-					Value: -1,
-					Unit:  "Metric",
-				}))
+					receptorClient.TasksReturns(tasks, nil)
+				})
 
-				Eventually(func() fake.Metric {
-					return sender.GetValue("TasksCompleted")
-				}).Should(Equal(fake.Metric{
-					Value: -1,
+				It("reports the most common ones, and counts the rest together", func() {
+					Eventually(func() fake.Metric {
+						return sender.GetValue("TasksFailedByReason.other")
+					}).Should(Equal(fake.Metric{
+						Value: 1 + 2 + 3 + 4 + 5,
+						Unit:  "Metric",
+					}))
+
+					Eventually(func() float64 {
+						return sender.GetValue("TasksFailedByReason.reason_o").Value
+					}).Should(Equal(float64(15)))
+
+					Expect(sender.GetValue("TasksFailedByReason.reason_e")).To(BeZero())
+				})
+			})
+
+			Context("when a domain is no longer fresh", func() {
+				JustBeforeEach(func() {
+					Eventually(func() fake.Metric {
+						return sender.GetValue("Domain.some-other-domain")
+					}).Should(Equal(fake.Metric{
+						Value: 1,
+						Unit:  "Metric",
+					}))
+
+					receptorClient.DomainsReturns([]string{"some-domain"}, nil)
+
+					fakeClock.Increment(reportInterval)
+				})
+
+				It("reports that the domain has expired", func() {
+					Eventually(func() fake.Metric {
+						return sender.GetValue("Domain.some-other-domain")
+					}).Should(Equal(fake.Metric{
+						Value: 0,
+						Unit:  "Metric",
+					}))
+
+					Expect(sender.GetValue("Domain.some-domain")).To(Equal(fake.Metric{
+						Value: 1,
+						Unit:  "Metric",
+					}))
+				})
+			})
+
+			It("emits metrics for tasks in each state per domain", func() {
+				expected := map[string]float64{
+					"TasksPending.domain":         2,
+					"TasksRunning.domain":         0,
+					"TasksCompleted.domain":       4,
+					"TasksResolving.domain":       1,
+					"TasksPending.other-domain":   1,
+					"TasksRunning.other-domain":   1,
+					"TasksCompleted.other-domain": 0,
+					"TasksResolving.other-domain": 1,
+				}
+
+				for name, value := range expected {
+					Eventually(func() fake.Metric {
+						return sender.GetValue(name)
+					}).Should(Equal(fake.Metric{
+						Value: value,
+						Unit:  "Metric",
+					}), name)
+				}
+			})
+
+			It("emits the resources required by the pending and running tasks", func() {
+				expected := map[string]fake.Metric{
+					"TasksPendingMemory":                 {Value: 768, Unit: "MiB"},
+					"TasksPendingDisk":                   {Value: 1536, Unit: "MiB"},
+					"TasksPendingCPUWeight":              {Value: 4, Unit: "Metric"},
+					"TasksRunningMemory":                 {Value: 64, Unit: "MiB"},
+					"TasksRunningDisk":                   {Value: 32, Unit: "MiB"},
+					"TasksRunningCPUWeight":              {Value: 5, Unit: "Metric"},
+					"TasksPendingMemory.domain":          {Value: 256, Unit: "MiB"},
+					"TasksRunningMemory.domain":          {Value: 0, Unit: "MiB"},
+					"TasksPendingCPUWeight.other-domain": {Value: 2, Unit: "Metric"},
+					"TasksRunningDisk.other-domain":      {Value: 32, Unit: "MiB"},
+				}
+
+				for name, metric := range expected {
+					Eventually(func() fake.Metric {
+						return sender.GetValue(name)
+					}).Should(Equal(metric), name)
+				}
+			})
+
+			It("emits the resources required by the desired LRPs", func() {
+				expected := map[string]fake.Metric{
+					"LRPsDesiredMemory":                 {Value: 1024, Unit: "MiB"},
+					"LRPsDesiredDisk":                   {Value: 2048, Unit: "MiB"},
+					"LRPsDesiredCPUWeight":              {Value: 80, Unit: "Metric"},
+					"LRPsDesiredMemory.domain":          {Value: 256, Unit: "MiB"},
+					"LRPsDesiredDisk.other-domain":      {Value: 1536, Unit: "MiB"},
+					"LRPsDesiredCPUWeight.other-domain": {Value: 60, Unit: "Metric"},
+				}
+
+				for name, metric := range expected {
+					Eventually(func() fake.Metric {
+						return sender.GetValue(name)
+					}).Should(Equal(metric), name)
+				}
+			})
+
+			It("emits the desired LRP instances and tasks on each stack", func() {
+				expected := map[string]float64{
+					"LRPInstancesDesiredByStack.cflinuxfs2": 2,
+					"LRPInstancesDesiredByStack.docker":     3,
+					"LRPInstancesDesiredByStack.other":      0,
+					"TasksByStack.cflinuxfs2":               2,
+					"TasksByStack.docker":                   1,
+					"TasksByStack.other":                    7,
+				}
+
+				for name, value := range expected {
+					Eventually(func() fake.Metric {
+						return sender.GetValue(name)
+					}).Should(Equal(fake.Metric{
+						Value: value,
+						Unit:  "Metric",
+					}), name)
+				}
+
+				Expect(sender.GetValue("TasksByStack.lucid64")).To(BeZero())
+			})
+
+			It("emits the routes and the running instances behind them", func() {
+				expected := map[string]float64{
+					"RoutesRegistered":       3,
+					"RoutesRunningInstances": 2,
+					"RoutesUnbacked":         1,
+				}
+
+				for name, value := range expected {
+					Eventually(func() fake.Metric {
+						return sender.GetValue(name)
+					}).Should(Equal(fake.Metric{
+						Value: value,
+						Unit:  "Metric",
+					}), name)
+				}
+			})
+
+			Context("when a route's port is not exposed by the running instances", func() {
+				BeforeEach(func() {
+					receptorClient.DesiredLRPsReturns([]receptor.DesiredLRPResponse{
+						{ProcessGuid: "desired-1", Domain: "domain", Instances: 2,
+							Routes: cfRoutes(`[{"hostnames": ["app-1.example.com"], "port": 9090}]`)},
+					}, nil)
+				})
+
+				It("reports the route as unbacked", func() {
+					Eventually(func() fake.Metric {
+						return sender.GetValue("RoutesUnbacked")
+					}).Should(Equal(fake.Metric{
+						Value: 1,
+						Unit:  "Metric",
+					}))
+
+					Expect(sender.GetValue("RoutesRunningInstances").Value).To(BeZero())
+				})
+			})
+
+			It("emits how far the actual LRPs are from the desired ones", func() {
+				expected := map[string]float64{
+					"LRPInstancesMissing": 3,
+					"LRPInstancesExtra":   4,
+					"LRPsConverged":       1,
+					"LRPsUnderReplicated": 1,
+				}
+
+				for name, value := range expected {
+					Eventually(func() fake.Metric {
+						return sender.GetValue(name)
+					}).Should(Equal(fake.Metric{
+						Value: value,
+						Unit:  "Metric",
+					}), name)
+				}
+			})
+
+			It("emits how long the oldest unconverged LRP has been unconverged", func() {
+				Eventually(func() string {
+					return sender.GetValue("OldestUnconvergedLRPAge").Unit
+				}).Should(Equal("nanos"))
+
+				Eventually(func() float64 {
+					fakeClock.Increment(reportInterval)
+					return sender.GetValue("OldestUnconvergedLRPAge").Value
+				}).Should(BeNumerically(">", 0))
+			})
+
+			Context("when instances are unclaimed or evacuating", func() {
+				BeforeEach(func() {
+					receptorClient.ActualLRPsReturns([]receptor.ActualLRPResponse{
+						{ProcessGuid: "desired-1", Index: 0, Domain: "domain", State: receptor.ActualLRPStateUnclaimed, PlacementError: "insufficient resources"},
+						{ProcessGuid: "desired-1", Index: 1, Domain: "domain", State: receptor.ActualLRPStateUnclaimed, PlacementError: "insufficient resources"},
+						{ProcessGuid: "desired-2", Index: 0, Domain: "other-domain", State: receptor.ActualLRPStateUnclaimed},
+						{ProcessGuid: "desired-2", Index: 1, Domain: "other-domain", State: receptor.ActualLRPStateRunning, CellID: "cell-1", Evacuating: true},
+						{ProcessGuid: "desired-2", Index: 1, Domain: "other-domain", State: receptor.ActualLRPStateClaimed, CellID: "cell-2"},
+					}, nil)
+				})
+
+				It("counts the unclaimed instances by placement error", func() {
+					expected := map[string]float64{
+						"LRPsUnclaimed":        3,
+						"LRPsUnclaimed.domain": 2,
+						"LRPsUnclaimedByPlacementError.insufficient_resources": 2,
+						"LRPsUnclaimedByPlacementError.none":                   1,
+					}
+
+					for name, value := range expected {
+						Eventually(func() fake.Metric {
+							return sender.GetValue(name)
+						}).Should(Equal(fake.Metric{
+							Value: value,
+							Unit:  "Metric",
+						}), name)
+					}
+				})
+
+				Context("when instances cannot be placed for many different reasons", func() {
+					BeforeEach(func() {
+						var lrps []receptor.ActualLRPResponse
+						for i := 0; i < 15; i++ {
+							for j := 0; j <= i; j++ {
+								lrps = append(lrps, receptor.ActualLRPResponse{
+									ProcessGuid:    fmt.Sprintf("process-%d", i),
+									Index:          j,
+									State:          receptor.ActualLRPStateUnclaimed,
+									PlacementError: fmt.Sprintf("found no cell with stack %c", 'a'+i),
+								})
+							}
+						}
+
+						receptorClient.ActualLRPsReturns(lrps, nil)
+					})
+
+					It("reports the most common ones, and counts the rest together", func() {
+						Eventually(func() fake.Metric {
+							return sender.GetValue("LRPsUnclaimedByPlacementError.other")
+						}).Should(Equal(fake.Metric{
+							Value: 1 + 2 + 3 + 4 + 5,
+							Unit:  "Metric",
+						}))
+
+						Eventually(func() float64 {
+							return sender.GetValue("LRPsUnclaimedByPlacementError.found_no_cell_with_stack_o").Value
+						}).Should(Equal(float64(15)))
+
+						Expect(sender.GetValue("LRPsUnclaimedByPlacementError.found_no_cell_with_stack_e")).To(BeZero())
+					})
+				})
+
+				It("counts the evacuating instances apart from the others", func() {
+					expected := map[string]float64{
+						"LRPsEvacuating":              1,
+						"LRPsEvacuating.other-domain": 1,
+						"LRPsRunning":                 0,
+						"LRPsStarting":                1,
+						"LRPInstancesMissing":         5,
+					}
+
+					for name, value := range expected {
+						Eventually(func() fake.Metric {
+							return sender.GetValue(name)
+						}).Should(Equal(fake.Metric{
+							Value: value,
+							Unit:  "Metric",
+						}), name)
+					}
+				})
+			})
+
+			Context("when every LRP has converged", func() {
+				BeforeEach(func() {
+					receptorClient.DesiredLRPsReturns([]receptor.DesiredLRPResponse{
+						{ProcessGuid: "desired-1", Domain: "domain", Instances: 2},
+					}, nil)
+
+					receptorClient.ActualLRPsReturns([]receptor.ActualLRPResponse{
+						{ProcessGuid: "desired-1", Index: 0, Domain: "domain", State: receptor.ActualLRPStateRunning},
+						{ProcessGuid: "desired-1", Index: 1, Domain: "domain", State: receptor.ActualLRPStateRunning},
+					}, nil)
+				})
+
+				It("emits no gap", func() {
+					Eventually(func() fake.Metric {
+						return sender.GetValue("LRPsConverged")
+					}).Should(Equal(fake.Metric{
+						Value: 1,
+						Unit:  "Metric",
+					}))
+
+					Consistently(func() float64 {
+						fakeClock.Increment(reportInterval)
+						return sender.GetValue("OldestUnconvergedLRPAge").Value
+					}).Should(BeZero())
+
+					Expect(sender.GetValue("LRPInstancesMissing").Value).To(BeZero())
+					Expect(sender.GetValue("LRPInstancesExtra").Value).To(BeZero())
+				})
+			})
+
+			It("emits a histogram of the actual LRPs' crash counts", func() {
+				expected := map[string]float64{
+					"ActualLRPCrashCounts.0":    4,
+					"ActualLRPCrashCounts.1":    4,
+					"ActualLRPCrashCounts.2":    5,
+					"ActualLRPCrashCounts.5":    5,
+					"ActualLRPCrashCounts.10":   6,
+					"ActualLRPCrashCounts.25":   6,
+					"ActualLRPCrashCounts.50":   7,
+					"ActualLRPCrashCounts.+Inf": 7,
+				}
+
+				for name, value := range expected {
+					Eventually(func() fake.Metric {
+						return sender.GetValue(name)
+					}).Should(Equal(fake.Metric{
+						Value: value,
+						Unit:  "Metric",
+					}), name)
+				}
+			})
+
+			It("counts the actual LRPs crash looping beyond the threshold", func() {
+				Eventually(func() fake.Metric {
+					return sender.GetValue("CrashLoopingActualLRPs")
+				}).Should(Equal(fake.Metric{
+					Value: 2,
+					Unit:  "Metric",
+				}))
+			})
+
+			It("reports the crashes of each crashing process", func() {
+				Eventually(func() fake.Metric {
+					return sender.GetValue("ProcessCrashCount.desired-4")
+				}).Should(Equal(fake.Metric{
+					Value: 30,
+					Unit:  "Metric",
+				}))
+
+				Eventually(func() float64 {
+					return sender.GetValue("ProcessCrashCount.desired-3").Value
+				}).Should(Equal(float64(9)))
+
+				Expect(sender.GetValue("ProcessCrashCount.desired-1")).To(BeZero())
+			})
+
+			Context("when many processes are crashing", func() {
+				BeforeEach(func() {
+					var actualLRPs []receptor.ActualLRPResponse
+					for i := 1; i <= 15; i++ {
+						actualLRPs = append(actualLRPs, receptor.ActualLRPResponse{
+							ProcessGuid: fmt.Sprintf("process-%02d", i),
+							State:       receptor.ActualLRPStateCrashed,
+							CrashCount:  i,
+						})
+					}
+
+					actualLRPs = append(actualLRPs,
+						receptor.ActualLRPResponse{ProcessGuid: "stale-crasher", State: receptor.ActualLRPStateRunning, CrashCount: 6, Since: 1},
+						receptor.ActualLRPResponse{ProcessGuid: "fresh-crasher", State: receptor.ActualLRPStateCrashed, CrashCount: 6, Since: 2},
+					)
+
+					receptorClient.ActualLRPsReturns(actualLRPs, nil)
+				})
+
+				It("reports only the worst crashing ones, most recently crashed first", func() {
+					Eventually(func() fake.Metric {
+						return sender.GetValue("ProcessCrashCount.process-15")
+					}).Should(Equal(fake.Metric{
+						Value: 15,
+						Unit:  "Metric",
+					}))
+
+					Eventually(func() float64 {
+						return sender.GetValue("ProcessCrashCount.fresh-crasher").Value
+					}).Should(Equal(float64(6)))
+
+					Eventually(func() float64 {
+						return sender.GetValue("ProcessCrashCount.process-07").Value
+					}).Should(Equal(float64(7)))
+
+					Expect(sender.GetValue("ProcessCrashCount.stale-crasher")).To(BeZero())
+					Expect(sender.GetValue("ProcessCrashCount.process-06")).To(BeZero())
+				})
+			})
+
+			It("emits LRP metrics per domain", func() {
+				expected := map[string]float64{
+					"LRPsDesired.domain":               2,
+					"LRPsStarting.domain":              0,
+					"LRPsRunning.domain":               3,
+					"CrashedActualLRPs.domain":         3,
+					"CrashingDesiredLRPs.domain":       2,
+					"LRPsDesired.other-domain":         3,
+					"LRPsStarting.other-domain":        1,
+					"LRPsRunning.other-domain":         0,
+					"CrashedActualLRPs.other-domain":   0,
+					"CrashingDesiredLRPs.other-domain": 0,
+				}
+
+				for name, value := range expected {
+					Eventually(func() fake.Metric {
+						return sender.GetValue(name)
+					}).Should(Equal(fake.Metric{
+						Value: value,
+						Unit:  "Metric",
+					}), name)
+				}
+			})
+
+			It("emits how work is placed on each cell", func() {
+				expected := map[string]fake.Metric{
+					"CellLRPsRunning.cell-1":        {Value: 2, Unit: "Metric"},
+					"CellTasksRunning.cell-1":       {Value: 1, Unit: "Metric"},
+					"CellMemoryReserved.cell-1":     {Value: 192, Unit: "MiB"},
+					"CellMemoryCapacity.cell-1":     {Value: 1024, Unit: "MiB"},
+					"CellDiskReserved.cell-1":       {Value: 288, Unit: "MiB"},
+					"CellDiskCapacity.cell-1":       {Value: 2048, Unit: "MiB"},
+					"CellContainersReserved.cell-1": {Value: 3, Unit: "Metric"},
+					"CellContainersCapacity.cell-1": {Value: 10, Unit: "Metric"},
+
+					"CellLRPsRunning.cell-2":        {Value: 1, Unit: "Metric"},
+					"CellTasksRunning.cell-2":       {Value: 0, Unit: "Metric"},
+					"CellMemoryReserved.cell-2":     {Value: 384, Unit: "MiB"},
+					"CellDiskReserved.cell-2":       {Value: 768, Unit: "MiB"},
+					"CellContainersReserved.cell-2": {Value: 2, Unit: "Metric"},
+				}
+
+				for name, metric := range expected {
+					Eventually(func() fake.Metric {
+						return sender.GetValue(name)
+					}).Should(Equal(metric), name)
+				}
+			})
+
+			It("emits cluster-wide cell capacity", func() {
+				expected := map[string]fake.Metric{
+					"CellsPresent":        {Value: 2, Unit: "Metric"},
+					"CellsFreeMemory":     {Value: 1472, Unit: "MiB"},
+					"CellsFreeDisk":       {Value: 3040, Unit: "MiB"},
+					"CellsFreeContainers": {Value: 15, Unit: "Metric"},
+				}
+
+				for name, metric := range expected {
+					Eventually(func() fake.Metric {
+						return sender.GetValue(name)
+					}).Should(Equal(metric), name)
+				}
+			})
+
+			It("emits desired LRP metrics", func() {
+				Eventually(func() fake.Metric {
+					return sender.GetValue("LRPsDesired")
+				}).Should(Equal(fake.Metric{
+					Value: 5,
+					Unit:  "Metric",
+				}))
+
+				Eventually(func() fake.Metric {
+					return sender.GetValue("LRPsStarting")
+				}).Should(Equal(fake.Metric{
+					Value: 1,
+					Unit:  "Metric",
+				}))
+
+				Eventually(func() fake.Metric {
+					return sender.GetValue("LRPsRunning")
+				}).Should(Equal(fake.Metric{
+					Value: 3,
+					Unit:  "Metric",
+				}))
+
+				Eventually(func() fake.Metric {
+					return sender.GetValue("CrashedActualLRPs")
+				}).Should(Equal(fake.Metric{
+					Value: 3,
+					Unit:  "Metric",
+				}))
+
+				Eventually(func() fake.Metric {
+					return sender.GetValue("CrashingDesiredLRPs")
+				}).Should(Equal(fake.Metric{
+					Value: 2,
+					Unit:  "Metric",
+				}))
+			})
+		})
+
+		Context("when an instrument takes longer than the instrument timeout", func() {
+			var unblockDomains chan struct{}
+			var domainsErr error
+
+			BeforeEach(func() {
+				instrumentTimeout = 10 * time.Second
+
+				unblockDomains = make(chan struct{})
+				domainsErr = nil
+				receptorClient.DomainsStub = func() ([]string, error) {
+					<-unblockDomains
+					return []string{"some-domain"}, domainsErr
+				}
+
+				receptorClient.TasksReturns([]receptor.TaskResponse{
+					{State: receptor.TaskStatePending},
+				}, nil)
+			})
+
+			AfterEach(func() {
+				close(unblockDomains)
+
+				// let the late instrument finish before the next test swaps the sender
+				Eventually(func() string {
+					return sender.GetValue("InstrumentDuration.domains").Unit
+				}).Should(Equal("nanos"))
+			})
+
+			It("still emits the metrics of the instruments that finished", func() {
+				Eventually(func() fake.Metric {
+					return sender.GetValue("TasksPending")
+				}).Should(Equal(fake.Metric{
+					Value: 1,
+					Unit:  "Metric",
+				}))
+			})
+
+			It("counts the timeout and finishes the report", func() {
+				Eventually(func() uint64 {
+					fakeClock.Increment(instrumentTimeout)
+					return sender.GetCounter("InstrumentTimeouts.domains")
+				}).Should(BeNumerically(">=", 1))
+
+				Eventually(func() string {
+					return sender.GetValue("MetricsReportingDuration").Unit
+				}).Should(Equal("nanos"))
+
+				Expect(sender.GetValue("Domain.some-domain")).To(Equal(fake.Metric{}))
+			})
+
+			It("does not start the instrument again while it is still running", func() {
+				Eventually(receptorClient.DomainsCallCount).Should(Equal(1))
+
+				for i := 0; i < 5; i++ {
+					fakeClock.Increment(instrumentTimeout)
+				}
+
+				Eventually(receptorClient.TasksCallCount).Should(BeNumerically(">", 1))
+				Consistently(receptorClient.DomainsCallCount).Should(Equal(1))
+			})
+
+			itRecordsTheTimeoutOnly := func() {
+				It("records the run as timed out, and only once", func() {
+					Eventually(func() uint64 {
+						fakeClock.Increment(instrumentTimeout)
+						return sender.GetCounter("InstrumentTimeouts.domains")
+					}).Should(Equal(uint64(1)))
+
+					Eventually(func() uint64 {
+						return sender.GetCounter("InstrumentCollectionFailures.domains")
+					}).Should(Equal(uint64(1)))
+
+					unblockDomains <- struct{}{}
+
+					Eventually(func() string {
+						return sender.GetValue("InstrumentDuration.domains").Unit
+					}).Should(Equal("nanos"))
+
+					Consistently(func() uint64 {
+						return sender.GetCounter("InstrumentCollectionFailures.domains")
+					}, aBit).Should(Equal(uint64(1)))
+
+					status := healthCheck.Status().Instruments["domains"]
+					Expect(status.Succeeded).To(BeFalse())
+					Expect(status.LastError).To(Equal("instrument timed out"))
+				})
+			}
+
+			Context("and then succeeds", func() {
+				itRecordsTheTimeoutOnly()
+			})
+
+			Context("and then fails", func() {
+				BeforeEach(func() {
+					domainsErr = errors.New("boom")
+				})
+
+				itRecordsTheTimeoutOnly()
+			})
+		})
+
+		It("lists the tasks and LRPs once per report, for all the instruments", func() {
+			Eventually(func() string {
+				return sender.GetValue("MetricsReportingDuration").Unit
+			}).Should(Equal("nanos"))
+
+			Expect(receptorClient.TasksCallCount()).To(Equal(1))
+			Expect(receptorClient.DesiredLRPsCallCount()).To(Equal(1))
+			Expect(receptorClient.ActualLRPsCallCount()).To(Equal(1))
+
+			fakeClock.Increment(reportInterval)
+
+			Eventually(receptorClient.TasksCallCount).Should(Equal(2))
+			Eventually(receptorClient.DesiredLRPsCallCount).Should(Equal(2))
+			Eventually(receptorClient.ActualLRPsCallCount).Should(Equal(2))
+
+			Consistently(receptorClient.TasksCallCount, aBit).Should(Equal(2))
+		})
+
+		Context("when an instrument starts failing after it has succeeded", func() {
+			BeforeEach(func() {
+				firstReport := fakeClock.Now().Add(reportInterval)
+
+				receptorClient.TasksStub = func() ([]receptor.TaskResponse, error) {
+					if fakeClock.Now().After(firstReport) {
+						return nil, errors.New("gone away")
+					}
+
+					return []receptor.TaskResponse{}, nil
+				}
+			})
+
+			It("keeps reporting when it last succeeded", func() {
+				Eventually(func() string {
+					return sender.GetValue("InstrumentLastSuccess.tasks").Unit
+				}).Should(Equal("Metric"))
+				lastSuccess := sender.GetValue("InstrumentLastSuccess.tasks").Value
+
+				Eventually(func() string {
+					return sender.GetValue("MetricsReportingDuration").Unit
+				}).Should(Equal("nanos"))
+
+				// a fresh sender only sees what the failing reports emit
+				sender = fake.NewFakeMetricSender()
+				dropsonde_metrics.Initialize(sender, nil)
+
+				fakeClock.Increment(reportInterval)
+
+				Eventually(func() uint64 {
+					return sender.GetCounter("InstrumentCollectionFailures.tasks")
+				}).Should(Equal(uint64(1)))
+
+				Eventually(func() fake.Metric {
+					return sender.GetValue("InstrumentLastSuccess.tasks")
+				}).Should(Equal(fake.Metric{
+					Value: lastSuccess,
+					Unit:  "Metric",
+				}))
+			})
+		})
+
+		Context("when the store cannot be reached", func() {
+			BeforeEach(func() {
+				receptorClient.TasksReturns(nil, errors.New("Doesn't work"))
+				receptorClient.DesiredLRPsReturns(nil, errors.New("no."))
+				receptorClient.ActualLRPsReturns(nil, errors.New("pushed to master"))
+			})
+
+			It("records that the receptor is unreachable", func() {
+				Eventually(func() string {
+					return healthCheck.Status().Instruments["tasks"].LastError
+				}).Should(Equal("Doesn't work"))
+
+				Eventually(func() bool {
+					return healthCheck.Status().Dependencies["receptor"].Reachable
+				}).Should(BeFalse())
+			})
+
+			It("reports -1 for all task metrics", func() {
+				Eventually(func() fake.Metric {
+					return sender.GetValue("TasksPending")
+				}).Should(Equal(fake.Metric{
+					Value: -1,
+					Unit:  "Metric",
+				}))
+
+				Eventually(func() fake.Metric {
+					return sender.GetValue("TasksRunning")
+				}).Should(Equal(fake.Metric{
+					Value: -1,
+					Unit:  "Metric",
+				}))
+
+				Eventually(func() fake.Metric {
+					return sender.GetValue("TasksCompleted")
+				}).Should(Equal(fake.Metric{
+					Value: -1,
 					Unit:  "Metric",
 				}))
 
@@ -467,7 +1728,561 @@ var _ = Describe("PeriodicMetronNotifier", func() {
 					Value: -1,
 					Unit:  "Metric",
 				}))
+
+				Eventually(func() fake.Metric {
+					return sender.GetValue("CrashedActualLRPs")
+				}).Should(Equal(fake.Metric{
+					Value: -1,
+					Unit:  "Metric",
+				}))
+
+				Eventually(func() fake.Metric {
+					return sender.GetValue("LRPsDesiredMemory")
+				}).Should(Equal(fake.Metric{
+					Value: -1,
+					Unit:  "MiB",
+				}))
+			})
+
+			It("reports -1 for the resources required by tasks", func() {
+				Eventually(func() fake.Metric {
+					return sender.GetValue("TasksPendingMemory")
+				}).Should(Equal(fake.Metric{
+					Value: -1,
+					Unit:  "MiB",
+				}))
+
+				Eventually(func() fake.Metric {
+					return sender.GetValue("TasksRunningCPUWeight")
+				}).Should(Equal(fake.Metric{
+					Value: -1,
+					Unit:  "Metric",
+				}))
+			})
+
+			It("reports -1 for the route metrics", func() {
+				Eventually(func() fake.Metric {
+					return sender.GetValue("RoutesUnbacked")
+				}).Should(Equal(fake.Metric{
+					Value: -1,
+					Unit:  "Metric",
+				}))
+			})
+
+			It("reports -1 for the cluster-wide cell metrics", func() {
+				Eventually(func() fake.Metric {
+					return sender.GetValue("CellsFreeMemory")
+				}).Should(Equal(fake.Metric{
+					Value: -1,
+					Unit:  "MiB",
+				}))
+			})
+
+			It("counts the failed collections", func() {
+				Eventually(func() uint64 {
+					return sender.GetCounter("InstrumentCollectionFailures.tasks")
+				}).Should(Equal(uint64(1)))
+
+				Eventually(func() uint64 {
+					return sender.GetCounter("InstrumentCollectionFailures.lrps")
+				}).Should(Equal(uint64(1)))
+
+				Expect(sender.GetValue("InstrumentLastSuccess.tasks")).To(BeZero())
+			})
+
+			Context("when configured to skip failed values", func() {
+				BeforeEach(func() {
+					failurePolicy = instruments.SkipOnFailure
+				})
+
+				It("reports nothing in their place", func() {
+					Eventually(func() string {
+						return sender.GetValue("MetricsReportingDuration").Unit
+					}).Should(Equal("nanos"))
+
+					Expect(sender.GetCounter("InstrumentCollectionFailures.lrps")).To(Equal(uint64(1)))
+
+					Expect(sender.GetValue("TasksPending")).To(BeZero())
+					Expect(sender.GetValue("LRPsDesired")).To(BeZero())
+					Expect(sender.GetValue("CrashedActualLRPs")).To(BeZero())
+					Expect(sender.GetValue("CellsPresent")).To(BeZero())
+				})
+			})
+		})
+
+		Context("when tasks and actual LRPs change state between reports", func() {
+			BeforeEach(func() {
+				firstReport := fakeClock.Now().Add(reportInterval)
+
+				receptorClient.TasksStub = func() ([]receptor.TaskResponse, error) {
+					if fakeClock.Now().After(firstReport) {
+						return []receptor.TaskResponse{
+							{TaskGuid: "task-1", State: receptor.TaskStateRunning},
+							{TaskGuid: "task-3", State: receptor.TaskStatePending},
+						}, nil
+					}
+
+					return []receptor.TaskResponse{
+						{TaskGuid: "task-1", State: receptor.TaskStatePending},
+						{TaskGuid: "task-2", State: receptor.TaskStateCompleted},
+					}, nil
+				}
+
+				receptorClient.ActualLRPsStub = func() ([]receptor.ActualLRPResponse, error) {
+					if fakeClock.Now().After(firstReport) {
+						return []receptor.ActualLRPResponse{
+							{ProcessGuid: "desired-1", Index: 0, State: receptor.ActualLRPStateCrashed, CrashCount: 1},
+							{ProcessGuid: "desired-1", Index: 1, State: receptor.ActualLRPStateRunning},
+							{ProcessGuid: "desired-1", Index: 2, State: receptor.ActualLRPStateRunning, CrashCount: 3},
+						}, nil
+					}
+
+					return []receptor.ActualLRPResponse{
+						{ProcessGuid: "desired-1", Index: 0, State: receptor.ActualLRPStateRunning},
+						{ProcessGuid: "desired-1", Index: 1, State: receptor.ActualLRPStateClaimed},
+						{ProcessGuid: "desired-1", Index: 2, State: receptor.ActualLRPStateCrashed, CrashCount: 2},
+					}, nil
+				}
+			})
+
+			JustBeforeEach(func() {
+				Eventually(func() string {
+					return sender.GetValue("InstrumentDuration.transitions").Unit
+				}).Should(Equal("nanos"))
+
+				fakeClock.Increment(reportInterval)
+			})
+
+			It("counts the tasks that moved between states", func() {
+				for _, name := range []string{
+					"TaskStateTransitions.pending.running",
+					"TaskStateTransitions.completed.none",
+					"TaskStateTransitions.none.pending",
+				} {
+					Eventually(func() uint64 {
+						return sender.GetCounter(name)
+					}).Should(Equal(uint64(1)), name)
+				}
+			})
+
+			It("counts the actual LRPs that moved between states, and their crashes", func() {
+				Eventually(func() uint64 {
+					return sender.GetCounter("ActualLRPCrashes")
+				}).Should(Equal(uint64(2)))
+
+				Expect(sender.GetCounter("ActualLRPStateTransitions.running.crashed")).To(Equal(uint64(1)))
+				Expect(sender.GetCounter("ActualLRPStateTransitions.claimed.running")).To(Equal(uint64(1)))
+				Expect(sender.GetCounter("ActualLRPStateTransitions.crashed.running")).To(Equal(uint64(1)))
+			})
+		})
+
+		Context("when caching the last good values", func() {
+			BeforeEach(func() {
+				maxStaleness = 10 * reportInterval
+
+				// other instruments list the tasks too, so only the first
+				// report succeeds
+				firstReport := fakeClock.Now().Add(reportInterval)
+
+				receptorClient.TasksStub = func() ([]receptor.TaskResponse, error) {
+					if fakeClock.Now().After(firstReport) {
+						return nil, errors.New("gone away")
+					}
+
+					return []receptor.TaskResponse{
+						{Domain: "domain", State: receptor.TaskStatePending},
+						{Domain: "domain", State: receptor.TaskStatePending},
+					}, nil
+				}
+			})
+
+			It("reports the values collected and how old they are", func() {
+				Eventually(func() fake.Metric {
+					return sender.GetValue("TasksPending.domain")
+				}).Should(Equal(fake.Metric{
+					Value: 2,
+					Unit:  "Metric",
+				}))
+
+				Eventually(func() fake.Metric {
+					return sender.GetValue("InstrumentStaleness.tasks")
+				}).Should(Equal(fake.Metric{
+					Value: 0,
+					Unit:  "nanos",
+				}))
+			})
+
+			It("reports the last good values again while collection fails", func() {
+				Eventually(func() float64 {
+					return sender.GetValue("TasksPending").Value
+				}).Should(Equal(float64(2)))
+
+				Eventually(func() uint64 {
+					fakeClock.Increment(reportInterval)
+					return sender.GetCounter("InstrumentCollectionFailures.tasks")
+				}).Should(BeNumerically(">=", 1))
+
+				Eventually(func() float64 {
+					return sender.GetValue("InstrumentStaleness.tasks").Value
+				}).Should(BeNumerically(">", 0))
+
+				Expect(sender.GetValue("TasksPending.stale").Value).To(Equal(float64(2)))
+				Expect(sender.GetValue("TasksPending.domain.stale").Value).To(Equal(float64(2)))
+			})
+
+			It("reports the failure once the values are too stale", func() {
+				Eventually(func() float64 {
+					return sender.GetValue("TasksPending").Value
+				}).Should(Equal(float64(2)))
+
+				Eventually(func() float64 {
+					fakeClock.Increment(reportInterval)
+					return sender.GetValue("TasksPending").Value
+				}).Should(Equal(float64(-1)))
+
+				Eventually(func() float64 {
+					return sender.GetValue("InstrumentStaleness.tasks").Value
+				}).Should(BeNumerically(">", maxStaleness))
+			})
+		})
+
+		Context("when caching the last good values and part of a collection fails", func() {
+			BeforeEach(func() {
+				maxStaleness = 10 * reportInterval
+
+				firstReport := fakeClock.Now().Add(reportInterval)
+
+				receptorClient.DesiredLRPsStub = func() ([]receptor.DesiredLRPResponse, error) {
+					if fakeClock.Now().After(firstReport) {
+						return nil, errors.New("gone away")
+					}
+
+					return []receptor.DesiredLRPResponse{
+						{ProcessGuid: "desired-1", Domain: "domain", Instances: 2},
+					}, nil
+				}
+
+				receptorClient.ActualLRPsStub = func() ([]receptor.ActualLRPResponse, error) {
+					running := []receptor.ActualLRPResponse{
+						{ProcessGuid: "desired-1", Domain: "domain", Index: 0, State: receptor.ActualLRPStateRunning},
+					}
+
+					if fakeClock.Now().After(firstReport) {
+						running = append(running, receptor.ActualLRPResponse{ProcessGuid: "desired-1", Domain: "domain", Index: 1, State: receptor.ActualLRPStateRunning})
+					}
+
+					return running, nil
+				}
+			})
+
+			It("reports the values it still collected, and the last good values for the rest", func() {
+				Eventually(func() float64 {
+					return sender.GetValue("LRPsRunning").Value
+				}).Should(Equal(float64(1)))
+
+				Eventually(func() uint64 {
+					fakeClock.Increment(reportInterval)
+					return sender.GetCounter("InstrumentCollectionFailures.lrps")
+				}).Should(BeNumerically(">=", 1))
+
+				Eventually(func() float64 {
+					return sender.GetValue("InstrumentStaleness.lrps").Value
+				}).Should(BeNumerically(">", 0))
+
+				Expect(sender.GetValue("LRPsRunning").Value).To(Equal(float64(2)))
+				Expect(sender.GetValue("LRPsRunning.stale")).To(BeZero())
+
+				Expect(sender.GetValue("LRPsDesired").Value).To(Equal(float64(2)))
+				Expect(sender.GetValue("LRPsDesired.stale").Value).To(Equal(float64(2)))
+			})
+		})
+
+		Context("when there are tasks of various ages", func() {
+			BeforeEach(func() {
+				taskAgeThresholds = []time.Duration{5 * time.Minute, time.Hour}
+
+				createdAgo := func(age time.Duration) int64 {
+					// the ages are measured once the report interval elapses
+					return fakeClock.Now().Add(reportInterval).Add(-age).UnixNano()
+				}
+
+				var tasks []receptor.TaskResponse
+				for minutes := 1; minutes <= 10; minutes++ {
+					tasks = append(tasks, receptor.TaskResponse{
+						TaskGuid:  fmt.Sprintf("pending-%d", minutes),
+						State:     receptor.TaskStatePending,
+						CreatedAt: createdAgo(time.Duration(minutes) * time.Minute),
+					})
+				}
+
+				tasks = append(tasks, receptor.TaskResponse{
+					TaskGuid:  "completed",
+					State:     receptor.TaskStateCompleted,
+					CreatedAt: createdAgo(2 * time.Hour),
+				})
+
+				receptorClient.TasksReturns(tasks, nil)
+			})
+
+			It("reports the percentiles of how long pending tasks have been pending", func() {
+				Eventually(func() fake.Metric {
+					return sender.GetValue("TasksPendingAge.max")
+				}).Should(Equal(fake.Metric{
+					Value: float64(10 * time.Minute),
+					Unit:  "nanos",
+				}))
+
+				Expect(sender.GetValue("TasksPendingAge.p50").Value).To(Equal(float64(5 * time.Minute)))
+				Expect(sender.GetValue("TasksPendingAge.p90").Value).To(Equal(float64(9 * time.Minute)))
+				Expect(sender.GetValue("TasksPendingAge.p99").Value).To(Equal(float64(10 * time.Minute)))
+			})
+
+			It("measures the time in other states from the report that first found the task in them", func() {
+				Eventually(func() float64 {
+					return sender.GetValue("TasksPendingAge.max").Value
+				}).Should(Equal(float64(10 * time.Minute)))
+
+				Expect(sender.GetValue("TasksCompletedAge.max").Value).To(BeZero())
+
+				fakeClock.Increment(reportInterval)
+
+				Eventually(func() float64 {
+					return sender.GetValue("TasksCompletedAge.max").Value
+				}).Should(Equal(float64(reportInterval)))
+			})
+
+			It("reports zero ages for states without tasks", func() {
+				Eventually(func() fake.Metric {
+					return sender.GetValue("TasksRunningAge.max")
+				}).Should(Equal(fake.Metric{
+					Value: 0,
+					Unit:  "nanos",
+				}))
+			})
+
+			It("counts the tasks older than each threshold", func() {
+				Eventually(func() fake.Metric {
+					return sender.GetValue("TasksPendingOverAge.5m0s")
+				}).Should(Equal(fake.Metric{
+					Value: 5,
+					Unit:  "Metric",
+				}))
+
+				Eventually(func() float64 {
+					return sender.GetValue("TasksPendingOverAge.1h0m0s").Value
+				}).Should(BeZero())
+			})
+		})
+
+		Context("when a task changes state", func() {
+			BeforeEach(func() {
+				firstReport := fakeClock.Now().Add(reportInterval)
+				createdAt := firstReport.Add(-time.Hour).UnixNano()
+
+				receptorClient.TasksStub = func() ([]receptor.TaskResponse, error) {
+					state := receptor.TaskStatePending
+					if fakeClock.Now().After(firstReport) {
+						state = receptor.TaskStateRunning
+					}
+
+					return []receptor.TaskResponse{
+						{TaskGuid: "task-1", State: state, CreatedAt: createdAt},
+					}, nil
+				}
+			})
+
+			It("measures its time in the new state from the report that found it there", func() {
+				Eventually(func() float64 {
+					return sender.GetValue("TasksPendingAge.max").Value
+				}).Should(Equal(float64(time.Hour)))
+
+				fakeClock.Increment(reportInterval)
+
+				Eventually(func() float64 {
+					return sender.GetValue("TasksPendingAge.max").Value
+				}).Should(BeZero())
+				Expect(sender.GetValue("TasksRunningAge.max").Value).To(BeZero())
+
+				fakeClock.Increment(reportInterval)
+
+				Eventually(func() float64 {
+					return sender.GetValue("TasksRunningAge.max").Value
+				}).Should(Equal(float64(reportInterval)))
+			})
+		})
+	})
+
+	Context("when an instrument is disabled", func() {
+		BeforeEach(func() {
+			instrumentSettings = map[string]metrics.InstrumentSettings{
+				"etcd": {Disabled: true},
+			}
+		})
+
+		It("does not run it", func() {
+			fakeClock.Increment(reportInterval)
+
+			Eventually(func() string {
+				return sender.GetValue("MetricsReportingDuration").Unit
+			}).Should(Equal("nanos"))
+
+			Expect(sender.GetValue("InstrumentDuration.tasks").Unit).To(Equal("nanos"))
+			Expect(sender.GetValue("InstrumentDuration.etcd")).To(BeZero())
+		})
+	})
+
+	Context("when an instrument has its own interval", func() {
+		BeforeEach(func() {
+			instrumentSettings = map[string]metrics.InstrumentSettings{
+				"domains": {Interval: 3 * reportInterval},
+			}
+		})
+
+		It("runs it on that interval", func() {
+			for cycles := 1; cycles <= 2; cycles++ {
+				fakeClock.Increment(reportInterval)
+				Eventually(receptorClient.CellsCallCount).Should(Equal(cycles))
+			}
+
+			Consistently(receptorClient.DomainsCallCount, aBit).Should(BeZero())
+
+			fakeClock.Increment(reportInterval)
+
+			Eventually(receptorClient.DomainsCallCount).Should(Equal(1))
+
+			Eventually(func() string {
+				return sender.GetValue("MetricsReportingDuration.300ms").Unit
+			}).Should(Equal("nanos"))
+		})
+
+		It("tells the health check how often it runs", func() {
+			fakeClock.Increment(3 * reportInterval)
+
+			Eventually(func() time.Duration {
+				return healthCheck.Status().Instruments["domains"].Interval
+			}).Should(Equal(3 * reportInterval))
+		})
+	})
+
+	Context("when every instrument has its own interval", func() {
+		BeforeEach(func() {
+			instrumentSettings = map[string]metrics.InstrumentSettings{}
+			for _, r := range instruments.Registered() {
+				instrumentSettings[r.Name] = metrics.InstrumentSettings{Interval: 3 * reportInterval}
+			}
+		})
+
+		It("still completes a report on every report interval", func() {
+			fakeClock.Increment(reportInterval)
+
+			Eventually(func() string {
+				return sender.GetValue("MetricsReportingDuration").Unit
+			}).Should(Equal("nanos"))
+
+			Expect(healthCheck.Status().LastReport).To(Equal(fakeClock.Now()))
+			Expect(receptorClient.TasksCallCount()).To(BeZero())
+		})
+	})
+
+	Context("when a config is given for an instrument that takes none", func() {
+		BeforeEach(func() {
+			instrumentSettings = map[string]metrics.InstrumentSettings{
+				"lrps": {Config: instruments.Config(`{"crash_loop_threshold": 5}`)},
+			}
+		})
+
+		It("fails to start", func() {
+			Eventually(pmn.Wait()).Should(Receive(MatchError("instrument lrps takes no config")))
+		})
+	})
+
+	Context("when settings are given for an unknown instrument", func() {
+		BeforeEach(func() {
+			instrumentSettings = map[string]metrics.InstrumentSettings{
+				"bogus": {Interval: time.Second},
+			}
+		})
+
+		It("fails to start", func() {
+			Eventually(pmn.Wait()).Should(Receive(MatchError(`unknown instrument: "bogus"`)))
+		})
+	})
+
+	Context("when an instrument is registered from another package", func() {
+		BeforeEach(func() {
+			registry = instruments.NewRegistry()
+			for _, r := range instruments.Registered() {
+				registry.Register(r.Name, r.Dependency, r.Factory)
+			}
+
+			registry.RegisterWithConfig("custom", "custom-dependency", newCustomInstrument, checkCustomConfig)
+		})
+
+		It("runs it along with the built-in instruments", func() {
+			fakeClock.Increment(reportInterval)
+
+			Eventually(func() float64 {
+				return sender.GetValue("CustomMetric").Value
+			}).Should(Equal(42.0))
+
+			Eventually(func() bool {
+				return healthCheck.Status().Dependencies["custom-dependency"].Reachable
+			}).Should(BeTrue())
+		})
+
+		Context("and it is disabled", func() {
+			BeforeEach(func() {
+				instrumentSettings = map[string]metrics.InstrumentSettings{
+					"custom": {Disabled: true},
+				}
+			})
+
+			It("does not run it", func() {
+				fakeClock.Increment(reportInterval)
+
+				Eventually(func() string {
+					return sender.GetValue("MetricsReportingDuration").Unit
+				}).Should(Equal("nanos"))
+
+				Expect(sender.GetValue("CustomMetric")).To(BeZero())
+			})
+		})
+
+		Context("and it has its own config", func() {
+			BeforeEach(func() {
+				instrumentSettings = map[string]metrics.InstrumentSettings{
+					"custom": {Config: instruments.Config(`{"value": 7}`)},
+				}
+			})
+
+			It("builds it from that config", func() {
+				fakeClock.Increment(reportInterval)
+
+				Eventually(func() float64 {
+					return sender.GetValue("CustomMetric").Value
+				}).Should(Equal(7.0))
+			})
+		})
+
+		Context("and its config is misspelt", func() {
+			BeforeEach(func() {
+				instrumentSettings = map[string]metrics.InstrumentSettings{
+					"custom": {Config: instruments.Config(`{"valeu": 7}`)},
+				}
+			})
+
+			It("fails to start", func() {
+				Eventually(pmn.Wait()).Should(Receive(MatchError(ContainSubstring("valeu"))))
 			})
 		})
 	})
+
+	It("refuses to register an instrument twice", func() {
+		registry := instruments.NewRegistry()
+		registry.Register("custom", "custom-dependency", newCustomInstrument)
+
+		Expect(func() {
+			registry.RegisterWithConfig("custom", "custom-dependency", newCustomInstrument, checkCustomConfig)
+		}).To(Panic())
+	})
 })
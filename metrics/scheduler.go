@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/instruments"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
+	"github.com/pivotal-golang/clock"
+)
+
+// InstrumentSettings select whether an instrument runs, how often, and what
+// its own config is. The zero value runs it on the report interval, as it is
+// configured by default.
+type InstrumentSettings struct {
+	Disabled bool
+	Interval time.Duration
+	Config   instruments.Config
+}
+
+// schedule is the collectors that run on the same interval.
+type schedule struct {
+	interval   time.Duration
+	collectors []*collector
+}
+
+// interval is how often the named instrument runs.
+func (notifier PeriodicMetronNotifier) interval(name string) time.Duration {
+	if settings := notifier.Instruments[name]; settings.Interval > 0 {
+		return settings.Interval
+	}
+
+	return notifier.Interval
+}
+
+// schedules groups the enabled collectors by interval, shortest first. There
+// is always a schedule on the report interval, even if no instrument runs on
+// it, so that reports keep completing on it.
+func (notifier PeriodicMetronNotifier) schedules(collectors []*collector) []*schedule {
+	grouped := map[time.Duration]*schedule{
+		notifier.Interval: {interval: notifier.Interval},
+	}
+
+	for _, c := range collectors {
+		s, found := grouped[c.interval]
+		if !found {
+			s = &schedule{interval: c.interval}
+			grouped[c.interval] = s
+		}
+
+		s.collectors = append(s.collectors, c)
+	}
+
+	schedules := make([]*schedule, 0, len(grouped))
+	for _, s := range grouped {
+		schedules = append(schedules, s)
+	}
+
+	sort.Sort(byInterval(schedules))
+
+	return schedules
+}
+
+// validateInstrumentSettings rejects settings for instruments that do not
+// exist, which are most likely misspelt, and configs their instruments do not
+// accept.
+func (notifier PeriodicMetronNotifier) validateInstrumentSettings() error {
+	for name, settings := range notifier.Instruments {
+		err := notifier.Registry.CheckConfig(name, settings.Config)
+		if err != nil {
+			return err
+		}
+
+		if settings.Interval < 0 {
+			return fmt.Errorf("negative interval for instrument %q", name)
+		}
+	}
+
+	return nil
+}
+
+// run collects on every tick until done, listing afresh from the source each
+// time. Schedules other than the report interval's tag how long their
+// collections took with their interval.
+func (notifier PeriodicMetronNotifier) run(s *schedule, source *instruments.SnapshotSource, ticker clock.Ticker, done <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer ticker.Stop()
+
+	var tags sink.Tags
+	if s.interval != notifier.Interval {
+		tags = sink.Tags{"interval": s.interval.String()}
+	}
+
+	for {
+		select {
+		case <-ticker.C():
+			startedAt := notifier.Dependencies.Clock.Now()
+
+			source.Expire()
+			notifier.collectAll(s.collectors)
+
+			finishedAt := notifier.Dependencies.Clock.Now()
+
+			notifier.Sink.Duration(metricsReportingDuration, finishedAt.Sub(startedAt), tags)
+			notifier.HealthCheck.ReportCompleted()
+
+		case <-done:
+			return
+		}
+	}
+}
+
+type byInterval []*schedule
+
+func (s byInterval) Len() int           { return len(s) }
+func (s byInterval) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byInterval) Less(i, j int) bool { return s[i].interval < s[j].interval }
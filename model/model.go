@@ -0,0 +1,305 @@
+package model
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-incubator/receptor"
+	"github.com/pivotal-golang/clock"
+	"github.com/pivotal-golang/lager"
+)
+
+var ErrNotSynced = errors.New("model has not been synced with receptor")
+
+// how long to wait before subscribing again, doubling on every failure in a
+// row
+const (
+	minRetryInterval = time.Second
+	maxRetryInterval = 30 * time.Second
+)
+
+type actualLRPKey struct {
+	processGuid string
+	index       int
+	evacuating  bool
+}
+
+func keyOf(lrp receptor.ActualLRPResponse) actualLRPKey {
+	return actualLRPKey{lrp.ProcessGuid, lrp.Index, lrp.Evacuating}
+}
+
+// Model keeps the desired and actual LRPs in memory, updated from receptor's
+// event stream and resynced with the full lists every resync interval to make
+// up for any events missed. Receptor streams no events for tasks, so they are
+// still read from receptor on every call.
+type Model struct {
+	logger         lager.Logger
+	receptorClient receptor.Client
+	clock          clock.Clock
+	resyncInterval time.Duration
+
+	lock        sync.RWMutex
+	synced      bool
+	desiredLRPs map[string]receptor.DesiredLRPResponse
+	actualLRPs  map[actualLRPKey]receptor.ActualLRPResponse
+}
+
+func New(logger lager.Logger, receptorClient receptor.Client, clock clock.Clock, resyncInterval time.Duration) *Model {
+	return &Model{
+		logger:         logger.Session("model"),
+		receptorClient: receptorClient,
+		clock:          clock,
+		resyncInterval: resyncInterval,
+	}
+}
+
+func (m *Model) Tasks() ([]receptor.TaskResponse, error) {
+	return m.receptorClient.Tasks()
+}
+
+func (m *Model) DesiredLRPs() ([]receptor.DesiredLRPResponse, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if !m.synced {
+		return nil, ErrNotSynced
+	}
+
+	lrps := make([]receptor.DesiredLRPResponse, 0, len(m.desiredLRPs))
+	for _, lrp := range m.desiredLRPs {
+		lrps = append(lrps, lrp)
+	}
+
+	return lrps, nil
+}
+
+func (m *Model) ActualLRPs() ([]receptor.ActualLRPResponse, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if !m.synced {
+		return nil, ErrNotSynced
+	}
+
+	lrps := make([]receptor.ActualLRPResponse, 0, len(m.actualLRPs))
+	for _, lrp := range m.actualLRPs {
+		lrps = append(lrps, lrp)
+	}
+
+	return lrps, nil
+}
+
+// Run follows the event stream until signalled. Until it has subscribed and
+// synced, and from when the stream breaks until it has subscribed and synced
+// again, the model reports ErrNotSynced rather than serving values that may
+// have drifted. It tries to subscribe again after a backoff, starting at a
+// second and doubling up to 30 seconds while it keeps failing.
+func (m *Model) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	ticker := m.clock.NewTicker(m.resyncInterval)
+	defer ticker.Stop()
+
+	close(ready)
+
+	var stream *eventStream
+	var retry clock.Timer
+	retryInterval := minRetryInterval
+
+	defer func() {
+		if stream != nil {
+			stream.close()
+		}
+
+		if retry != nil {
+			retry.Stop()
+		}
+	}()
+
+	retryLater := func() {
+		retry = m.clock.NewTimer(retryInterval)
+
+		retryInterval *= 2
+		if retryInterval > maxRetryInterval {
+			retryInterval = maxRetryInterval
+		}
+	}
+
+	connect := func() {
+		stream = m.connect()
+		if stream == nil {
+			retryLater()
+			return
+		}
+
+		retryInterval = minRetryInterval
+	}
+
+	connect()
+
+	for {
+		var events <-chan receptor.Event
+		var errs <-chan error
+		if stream != nil {
+			events = stream.events
+			errs = stream.errs
+		}
+
+		var retries <-chan time.Time
+		if retry != nil {
+			retries = retry.C()
+		}
+
+		select {
+		case event := <-events:
+			m.apply(event)
+
+		case err := <-errs:
+			m.logger.Error("event-stream-failed", err)
+			m.setUnsynced()
+
+			stream.close()
+			stream = nil
+
+			retryLater()
+
+		case <-retries:
+			retry = nil
+			connect()
+
+		case <-ticker.C():
+			if stream == nil {
+				continue
+			}
+
+			if err := m.resync(); err != nil {
+				m.logger.Error("failed-to-resync", err)
+			}
+
+		case <-signals:
+			return nil
+		}
+	}
+}
+
+// connect subscribes to the event stream before listing the LRPs, so that no
+// change made in between is missed.
+func (m *Model) connect() *eventStream {
+	source, err := m.receptorClient.SubscribeToEvents()
+	if err != nil {
+		m.logger.Error("failed-to-subscribe-to-events", err)
+		return nil
+	}
+
+	stream := newEventStream(source)
+
+	err = m.resync()
+	if err != nil {
+		m.logger.Error("failed-to-resync", err)
+		stream.close()
+		return nil
+	}
+
+	return stream
+}
+
+func (m *Model) resync() error {
+	desiredLRPs, err := m.receptorClient.DesiredLRPs()
+	if err != nil {
+		return err
+	}
+
+	actualLRPs, err := m.receptorClient.ActualLRPs()
+	if err != nil {
+		return err
+	}
+
+	desired := make(map[string]receptor.DesiredLRPResponse, len(desiredLRPs))
+	for _, lrp := range desiredLRPs {
+		desired[lrp.ProcessGuid] = lrp
+	}
+
+	actual := make(map[actualLRPKey]receptor.ActualLRPResponse, len(actualLRPs))
+	for _, lrp := range actualLRPs {
+		actual[keyOf(lrp)] = lrp
+	}
+
+	m.lock.Lock()
+	m.desiredLRPs = desired
+	m.actualLRPs = actual
+	m.synced = true
+	m.lock.Unlock()
+
+	return nil
+}
+
+func (m *Model) setUnsynced() {
+	m.lock.Lock()
+	m.synced = false
+	m.lock.Unlock()
+}
+
+func (m *Model) apply(event receptor.Event) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	switch e := event.(type) {
+	case receptor.DesiredLRPCreatedEvent:
+		m.desiredLRPs[e.DesiredLRPResponse.ProcessGuid] = e.DesiredLRPResponse
+	case receptor.DesiredLRPChangedEvent:
+		m.desiredLRPs[e.After.ProcessGuid] = e.After
+	case receptor.DesiredLRPRemovedEvent:
+		delete(m.desiredLRPs, e.DesiredLRPResponse.ProcessGuid)
+	case receptor.ActualLRPCreatedEvent:
+		m.actualLRPs[keyOf(e.ActualLRPResponse)] = e.ActualLRPResponse
+	case receptor.ActualLRPChangedEvent:
+		delete(m.actualLRPs, keyOf(e.Before))
+		m.actualLRPs[keyOf(e.After)] = e.After
+	case receptor.ActualLRPRemovedEvent:
+		delete(m.actualLRPs, keyOf(e.ActualLRPResponse))
+	default:
+		m.logger.Debug("ignoring-event", lager.Data{"type": event.EventType()})
+	}
+}
+
+// eventStream reads events off a source until it fails or is closed.
+type eventStream struct {
+	source receptor.EventSource
+	events chan receptor.Event
+	errs   chan error
+	done   chan struct{}
+}
+
+func newEventStream(source receptor.EventSource) *eventStream {
+	stream := &eventStream{
+		source: source,
+		events: make(chan receptor.Event),
+		errs:   make(chan error, 1),
+		done:   make(chan struct{}),
+	}
+
+	go stream.read()
+
+	return stream
+}
+
+func (s *eventStream) read() {
+	for {
+		event, err := s.source.Next()
+		if err != nil {
+			s.errs <- err
+			return
+		}
+
+		select {
+		case s.events <- event:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *eventStream) close() {
+	close(s.done)
+	s.source.Close()
+}
@@ -0,0 +1,224 @@
+package model_test
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/cloudfoundry-incubator/receptor"
+	"github.com/cloudfoundry-incubator/receptor/fake_receptor"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/model"
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/pivotal-golang/lager/lagertest"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func newEventSource(events <-chan receptor.Event) *fake_receptor.FakeEventSource {
+	source := new(fake_receptor.FakeEventSource)
+	source.NextStub = func() (receptor.Event, error) {
+		event, ok := <-events
+		if !ok {
+			return nil, receptor.ErrReadFromClosedSource
+		}
+		return event, nil
+	}
+	return source
+}
+
+var _ = Describe("Model", func() {
+	const resyncInterval = time.Minute
+
+	var (
+		receptorClient *fake_receptor.FakeClient
+		eventSource    *fake_receptor.FakeEventSource
+		events         chan receptor.Event
+		fakeClock      *fakeclock.FakeClock
+
+		lrpModel *model.Model
+		process  ifrit.Process
+	)
+
+	desiredGuids := func() []string {
+		lrps, err := lrpModel.DesiredLRPs()
+		if err != nil {
+			return nil
+		}
+
+		guids := []string{}
+		for _, lrp := range lrps {
+			guids = append(guids, lrp.ProcessGuid)
+		}
+		return guids
+	}
+
+	actualStates := func() map[int]string {
+		lrps, err := lrpModel.ActualLRPs()
+		if err != nil {
+			return nil
+		}
+
+		states := map[int]string{}
+		for _, lrp := range lrps {
+			states[lrp.Index] = lrp.State
+		}
+		return states
+	}
+
+	BeforeEach(func() {
+		receptorClient = new(fake_receptor.FakeClient)
+		fakeClock = fakeclock.NewFakeClock(time.Unix(123, 456))
+
+		events = make(chan receptor.Event, 10)
+		eventSource = newEventSource(events)
+		receptorClient.SubscribeToEventsReturns(eventSource, nil)
+
+		receptorClient.DesiredLRPsReturns([]receptor.DesiredLRPResponse{
+			{ProcessGuid: "desired-1", Instances: 2},
+		}, nil)
+
+		receptorClient.ActualLRPsReturns([]receptor.ActualLRPResponse{
+			{ProcessGuid: "desired-1", Index: 0, State: receptor.ActualLRPStateRunning},
+			{ProcessGuid: "desired-1", Index: 1, State: receptor.ActualLRPStateClaimed},
+		}, nil)
+
+		lrpModel = model.New(lagertest.NewTestLogger("test"), receptorClient, fakeClock, resyncInterval)
+	})
+
+	JustBeforeEach(func() {
+		process = ifrit.Invoke(lrpModel)
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive())
+	})
+
+	It("syncs with the full lists of LRPs", func() {
+		Eventually(desiredGuids).Should(ConsistOf("desired-1"))
+		Expect(actualStates()).To(Equal(map[int]string{
+			0: receptor.ActualLRPStateRunning,
+			1: receptor.ActualLRPStateClaimed,
+		}))
+	})
+
+	It("reads tasks straight from receptor", func() {
+		receptorClient.TasksReturns([]receptor.TaskResponse{{TaskGuid: "task-1"}}, nil)
+
+		tasks, err := lrpModel.Tasks()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tasks).To(HaveLen(1))
+	})
+
+	It("applies the events to the LRPs", func() {
+		Eventually(desiredGuids).Should(ConsistOf("desired-1"))
+
+		events <- receptor.DesiredLRPCreatedEvent{DesiredLRPResponse: receptor.DesiredLRPResponse{ProcessGuid: "desired-2"}}
+		events <- receptor.DesiredLRPRemovedEvent{DesiredLRPResponse: receptor.DesiredLRPResponse{ProcessGuid: "desired-1"}}
+		events <- receptor.ActualLRPChangedEvent{
+			Before: receptor.ActualLRPResponse{ProcessGuid: "desired-1", Index: 1, State: receptor.ActualLRPStateClaimed},
+			After:  receptor.ActualLRPResponse{ProcessGuid: "desired-1", Index: 1, State: receptor.ActualLRPStateRunning},
+		}
+		events <- receptor.ActualLRPRemovedEvent{ActualLRPResponse: receptor.ActualLRPResponse{ProcessGuid: "desired-1", Index: 0}}
+
+		Eventually(actualStates).Should(Equal(map[int]string{
+			1: receptor.ActualLRPStateRunning,
+		}))
+		Expect(desiredGuids()).To(ConsistOf("desired-2"))
+	})
+
+	It("resyncs on the resync interval", func() {
+		Eventually(desiredGuids).Should(ConsistOf("desired-1"))
+
+		receptorClient.DesiredLRPsReturns([]receptor.DesiredLRPResponse{
+			{ProcessGuid: "desired-3", Instances: 1},
+		}, nil)
+
+		fakeClock.Increment(resyncInterval)
+
+		Eventually(desiredGuids).Should(ConsistOf("desired-3"))
+		Expect(receptorClient.SubscribeToEventsCallCount()).To(Equal(1))
+	})
+
+	Context("when it cannot subscribe to the event stream", func() {
+		var subscriptions chan receptor.EventSource
+
+		BeforeEach(func() {
+			subscriptions = make(chan receptor.EventSource, 1)
+			receptorClient.SubscribeToEventsStub = func() (receptor.EventSource, error) {
+				select {
+				case source := <-subscriptions:
+					return source, nil
+				default:
+					return nil, errors.New("boom")
+				}
+			}
+		})
+
+		It("reports that it has not synced", func() {
+			Eventually(receptorClient.SubscribeToEventsCallCount).Should(Equal(1))
+
+			_, err := lrpModel.DesiredLRPs()
+			Expect(err).To(Equal(model.ErrNotSynced))
+
+			_, err = lrpModel.ActualLRPs()
+			Expect(err).To(Equal(model.ErrNotSynced))
+		})
+
+		It("tries again after a second", func() {
+			Eventually(receptorClient.SubscribeToEventsCallCount).Should(Equal(1))
+			Eventually(fakeClock.WatcherCount).Should(Equal(2))
+
+			subscriptions <- eventSource
+			fakeClock.Increment(time.Second)
+
+			Eventually(desiredGuids).Should(ConsistOf("desired-1"))
+		})
+
+		It("waits longer each time it fails again", func() {
+			Eventually(fakeClock.WatcherCount).Should(Equal(2))
+			fakeClock.Increment(time.Second)
+
+			Eventually(receptorClient.SubscribeToEventsCallCount).Should(Equal(2))
+			Eventually(fakeClock.WatcherCount).Should(Equal(2))
+			fakeClock.Increment(time.Second)
+
+			Consistently(receptorClient.SubscribeToEventsCallCount).Should(Equal(2))
+
+			fakeClock.Increment(time.Second)
+
+			Eventually(receptorClient.SubscribeToEventsCallCount).Should(Equal(3))
+		})
+	})
+
+	Context("when the event stream fails", func() {
+		JustBeforeEach(func() {
+			Eventually(desiredGuids).Should(ConsistOf("desired-1"))
+			close(events)
+		})
+
+		It("reports that it is no longer synced", func() {
+			Eventually(func() error {
+				_, err := lrpModel.DesiredLRPs()
+				return err
+			}).Should(Equal(model.ErrNotSynced))
+		})
+
+		It("subscribes again and resyncs after a second", func() {
+			Eventually(eventSource.CloseCallCount).Should(Equal(1))
+			Eventually(fakeClock.WatcherCount).Should(Equal(2))
+
+			receptorClient.SubscribeToEventsReturns(newEventSource(make(chan receptor.Event)), nil)
+			receptorClient.DesiredLRPsReturns([]receptor.DesiredLRPResponse{
+				{ProcessGuid: "desired-3", Instances: 1},
+			}, nil)
+
+			fakeClock.Increment(time.Second)
+
+			Eventually(desiredGuids).Should(ConsistOf("desired-3"))
+			Expect(receptorClient.SubscribeToEventsCallCount()).To(Equal(2))
+		})
+	})
+})
@@ -0,0 +1,194 @@
+package prometheus
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
+)
+
+const (
+	namespace   = "diego"
+	contentType = "text/plain; version=0.0.4"
+)
+
+type unitInfo struct {
+	suffix      string
+	scale       float64
+	description string
+}
+
+// units maps the sink units, which mirror runtime-schema's metric kinds, onto
+// Prometheus base units. Unitless values are described by their name alone.
+var units = map[string]unitInfo{
+	"Metric": {"", 1, ""},
+	"B/s":    {"_bytes_per_second", 1, "bytes per second"},
+	"Req/s":  {"_requests_per_second", 1, "requests per second"},
+	"nanos":  {"_seconds", 1e-9, "seconds"},
+	"MiB":    {"_bytes", 1 << 20, "bytes"},
+	"B":      {"_bytes", 1, "bytes"},
+}
+
+type handler struct {
+	registry *Registry
+}
+
+// NewHandler serves the registry's current values in the Prometheus text
+// exposition format.
+func NewHandler(registry *Registry) http.Handler {
+	return &handler{registry: registry}
+}
+
+type series struct {
+	family     string
+	help       string
+	metricType string
+	labels     string
+	value      float64
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	samples := h.registry.snapshot()
+
+	allSeries := make([]series, 0, len(samples))
+	for _, s := range samples {
+		allSeries = append(allSeries, describe(s))
+	}
+
+	sort.Sort(byFamilyAndLabels(allSeries))
+
+	var family string
+	for i, s := range allSeries {
+		// distinct metric names may sanitize to the same series; only the first
+		// one wins, as Prometheus rejects duplicate series
+		if i > 0 && s.family == allSeries[i-1].family && s.labels == allSeries[i-1].labels {
+			continue
+		}
+
+		if s.family != family {
+			family = s.family
+			fmt.Fprintf(out, "# HELP %s %s\n", s.family, escapeHelp(s.help))
+			fmt.Fprintf(out, "# TYPE %s %s\n", s.family, s.metricType)
+		}
+
+		fmt.Fprintf(out, "%s%s %s\n", s.family, s.labels, strconv.FormatFloat(s.value, 'g', -1, 64))
+	}
+}
+
+func describe(s sample) series {
+	labels := formatLabels(s.tags)
+
+	if s.counter {
+		return series{
+			family:     namespace + "_" + metricName(s.name) + "_total",
+			help:       s.name,
+			metricType: "counter",
+			labels:     labels,
+			value:      s.value,
+		}
+	}
+
+	unit, found := units[s.unit]
+	if !found {
+		unit = unitInfo{"_" + sanitize(strings.ToLower(s.unit)), 1, s.unit}
+	}
+
+	help := s.name
+	if unit.description != "" {
+		help += ", in " + unit.description
+	}
+
+	return series{
+		family:     namespace + "_" + metricName(s.name) + unit.suffix,
+		help:       help,
+		metricType: "gauge",
+		labels:     labels,
+		value:      s.value * unit.scale,
+	}
+}
+
+func formatLabels(tags sink.Tags) string {
+	if len(tags) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(tags))
+	for _, key := range sortedKeys(tags) {
+		pairs = append(pairs, sanitize(key)+`="`+escapeLabelValue(tags[key])+`"`)
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// metricName converts a dropsonde metric name such as "ETCDRaftTerm" or
+// "Domain.cf-apps" into a Prometheus-friendly snake_case name.
+func metricName(name string) string {
+	runes := []rune(name)
+
+	var converted []rune
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 && startsWord(runes, i) {
+			converted = append(converted, '_')
+		}
+
+		converted = append(converted, unicode.ToLower(r))
+	}
+
+	return sanitize(string(converted))
+}
+
+func startsWord(runes []rune, i int) bool {
+	prev := runes[i-1]
+	if unicode.IsLower(prev) || unicode.IsDigit(prev) {
+		return true
+	}
+
+	if !unicode.IsUpper(prev) || i+1 >= len(runes) || !unicode.IsLower(runes[i+1]) {
+		return false
+	}
+
+	// keep pluralized acronyms such as "LRPs" together
+	if runes[i+1] == 's' && (i+2 == len(runes) || !unicode.IsLower(runes[i+2])) {
+		return false
+	}
+
+	return true
+}
+
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == ':' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(value)
+}
+
+type byFamilyAndLabels []series
+
+func (s byFamilyAndLabels) Len() int      { return len(s) }
+func (s byFamilyAndLabels) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byFamilyAndLabels) Less(i, j int) bool {
+	if s[i].family != s[j].family {
+		return s[i].family < s[j].family
+	}
+	return s[i].labels < s[j].labels
+}
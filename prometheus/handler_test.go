@@ -0,0 +1,115 @@
+package prometheus_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/prometheus"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
+	"github.com/pivotal-golang/clock/fakeclock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Handler", func() {
+	var (
+		fakeClock *fakeclock.FakeClock
+		registry  *prometheus.Registry
+		server    *httptest.Server
+	)
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Unix(123, 456))
+		registry = prometheus.NewRegistry(fakeClock, time.Minute)
+		server = httptest.NewServer(prometheus.NewHandler(registry))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	scrape := func() string {
+		resp, err := http.Get(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("Content-Type")).To(Equal("text/plain; version=0.0.4"))
+
+		body, err := ioutil.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+
+		return string(body)
+	}
+
+	Context("when metrics have been emitted to the registry", func() {
+		BeforeEach(func() {
+			registry.Gauge("LRPsDesired", 5, sink.Metric, nil)
+			registry.Gauge("ETCDReceivedBandwidthRate", 2, sink.BytesPerSecond, nil)
+			registry.Gauge("ETCDSentRequestRate", 4, sink.RequestsPerSecond, nil)
+			registry.Gauge("Domain", 1, sink.Metric, sink.Tags{"domain": "cf-apps"})
+			registry.Gauge("Domain", 1, sink.Metric, sink.Tags{"domain": `cf-"tasks"`})
+			registry.Gauge("CellMemory", 2, sink.Mebibytes, sink.Tags{"cell": "cell-z1-0", "zone": "z1"})
+			registry.Duration("MetricsReportingDuration", 1500*time.Millisecond, nil)
+			registry.Counter("SomeCounter", 1, nil)
+			registry.Counter("SomeCounter", 2, nil)
+		})
+
+		It("serves the latest values in the text exposition format", func() {
+			Expect(scrape()).To(Equal(`# HELP diego_cell_memory_bytes CellMemory, in bytes
+# TYPE diego_cell_memory_bytes gauge
+diego_cell_memory_bytes{cell="cell-z1-0",zone="z1"} 2.097152e+06
+# HELP diego_domain Domain
+# TYPE diego_domain gauge
+diego_domain{domain="cf-\"tasks\""} 1
+diego_domain{domain="cf-apps"} 1
+# HELP diego_etcd_received_bandwidth_rate_bytes_per_second ETCDReceivedBandwidthRate, in bytes per second
+# TYPE diego_etcd_received_bandwidth_rate_bytes_per_second gauge
+diego_etcd_received_bandwidth_rate_bytes_per_second 2
+# HELP diego_etcd_sent_request_rate_requests_per_second ETCDSentRequestRate, in requests per second
+# TYPE diego_etcd_sent_request_rate_requests_per_second gauge
+diego_etcd_sent_request_rate_requests_per_second 4
+# HELP diego_lrps_desired LRPsDesired
+# TYPE diego_lrps_desired gauge
+diego_lrps_desired 5
+# HELP diego_metrics_reporting_duration_seconds MetricsReportingDuration, in seconds
+# TYPE diego_metrics_reporting_duration_seconds gauge
+diego_metrics_reporting_duration_seconds 1.5
+# HELP diego_some_counter_total SomeCounter
+# TYPE diego_some_counter_total counter
+diego_some_counter_total 3
+`))
+		})
+
+		Context("when a value is updated", func() {
+			BeforeEach(func() {
+				registry.Gauge("LRPsDesired", 7, sink.Metric, nil)
+			})
+
+			It("serves the latest value", func() {
+				Expect(scrape()).To(ContainSubstring("diego_lrps_desired 7\n"))
+			})
+		})
+
+		Context("when values have not been updated within the expiry window", func() {
+			BeforeEach(func() {
+				fakeClock.Increment(30 * time.Second)
+				registry.Gauge("LRPsDesired", 7, sink.Metric, nil)
+				fakeClock.Increment(31 * time.Second)
+			})
+
+			It("stops serving the gauges but keeps serving the counters", func() {
+				Expect(scrape()).To(Equal(`# HELP diego_lrps_desired LRPsDesired
+# TYPE diego_lrps_desired gauge
+diego_lrps_desired 7
+# HELP diego_some_counter_total SomeCounter
+# TYPE diego_some_counter_total counter
+diego_some_counter_total 3
+`))
+			})
+		})
+	})
+})
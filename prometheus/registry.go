@@ -0,0 +1,133 @@
+package prometheus
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
+	"github.com/pivotal-golang/clock"
+)
+
+const durationUnit = "nanos"
+
+type sample struct {
+	name      string
+	tags      sink.Tags
+	value     float64
+	unit      string
+	counter   bool
+	updatedAt time.Time
+}
+
+// Registry is a sink that remembers the most recent value of every metric
+// that has been emitted, so that it can be scraped at any time.
+//
+// Gauges that have not been updated within the expiry window are dropped; a
+// zero expiry keeps them forever. Counters are never dropped, as many are only
+// incremented when something happens, and a counter that disappears and comes
+// back from zero reads as a reset to rate() and increase().
+type Registry struct {
+	clock  clock.Clock
+	expiry time.Duration
+
+	lock    sync.Mutex
+	samples map[string]*sample
+}
+
+func NewRegistry(clock clock.Clock, expiry time.Duration) *Registry {
+	return &Registry{
+		clock:   clock,
+		expiry:  expiry,
+		samples: map[string]*sample{},
+	}
+}
+
+func (r *Registry) Gauge(name string, value float64, unit sink.Unit, tags sink.Tags) {
+	r.set(name, value, string(unit), tags)
+}
+
+func (r *Registry) Duration(name string, duration time.Duration, tags sink.Tags) {
+	r.set(name, float64(duration), durationUnit, tags)
+}
+
+func (r *Registry) Counter(name string, delta uint64, tags sink.Tags) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key := seriesKey(name, tags)
+
+	s, found := r.samples[key]
+	if !found || !s.counter {
+		s = &sample{name: name, tags: copyTags(tags), counter: true}
+		r.samples[key] = s
+	}
+
+	s.value += float64(delta)
+	s.updatedAt = r.clock.Now()
+}
+
+func (r *Registry) set(name string, value float64, unit string, tags sink.Tags) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.samples[seriesKey(name, tags)] = &sample{
+		name:      name,
+		tags:      copyTags(tags),
+		value:     value,
+		unit:      unit,
+		updatedAt: r.clock.Now(),
+	}
+}
+
+func (r *Registry) snapshot() []sample {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.clock.Now()
+
+	samples := make([]sample, 0, len(r.samples))
+	for key, s := range r.samples {
+		if !s.counter && r.expiry > 0 && now.Sub(s.updatedAt) > r.expiry {
+			delete(r.samples, key)
+			continue
+		}
+
+		samples = append(samples, *s)
+	}
+
+	return samples
+}
+
+func seriesKey(name string, tags sink.Tags) string {
+	key := []string{name}
+	for _, tag := range sortedKeys(tags) {
+		key = append(key, tag+"="+tags[tag])
+	}
+
+	return strings.Join(key, "\x00")
+}
+
+func sortedKeys(tags sink.Tags) []string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func copyTags(tags sink.Tags) sink.Tags {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	copied := make(sink.Tags, len(tags))
+	for key, value := range tags {
+		copied[key] = value
+	}
+
+	return copied
+}
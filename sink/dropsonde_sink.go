@@ -0,0 +1,63 @@
+package sink
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/metrics"
+)
+
+const durationUnit = "nanos"
+
+type dropsondeSink struct{}
+
+// NewDropsondeSink emits metrics through the globally initialized dropsonde
+// metric sender, exactly as runtime-schema's metric kinds do.
+//
+// Dropsonde value metrics cannot carry tags, so tag values are appended to
+// the metric name in tag key order, e.g. "LRPsRunning.cf-apps". Stale values
+// are told apart by a final "stale", e.g. "LRPsRunning.cf-apps.stale".
+func NewDropsondeSink() Sink {
+	return dropsondeSink{}
+}
+
+func (dropsondeSink) Gauge(name string, value float64, unit Unit, tags Tags) {
+	metrics.SendValue(flattenName(name, tags), value, string(unit))
+}
+
+func (dropsondeSink) Counter(name string, delta uint64, tags Tags) {
+	metrics.AddToCounter(flattenName(name, tags), delta)
+}
+
+func (dropsondeSink) Duration(name string, duration time.Duration, tags Tags) {
+	metrics.SendValue(flattenName(name, tags), float64(duration), durationUnit)
+}
+
+// flattenName appends the tag values to the name, ordered by tag key, and
+// then the stale marker.
+func flattenName(name string, tags Tags) string {
+	if len(tags) == 0 {
+		return name
+	}
+
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		if key != StaleTag {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(tags)+1)
+	parts = append(parts, name)
+	for _, key := range keys {
+		parts = append(parts, tags[key])
+	}
+
+	if _, stale := tags[StaleTag]; stale {
+		parts = append(parts, StaleTag)
+	}
+
+	return strings.Join(parts, ".")
+}
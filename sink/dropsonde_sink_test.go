@@ -0,0 +1,75 @@
+package sink_test
+
+import (
+	"time"
+
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
+	"github.com/cloudfoundry/dropsonde/metric_sender/fake"
+	dropsonde_metrics "github.com/cloudfoundry/dropsonde/metrics"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DropsondeSink", func() {
+	var (
+		sender     *fake.FakeMetricSender
+		metricSink sink.Sink
+	)
+
+	BeforeEach(func() {
+		sender = fake.NewFakeMetricSender()
+		dropsonde_metrics.Initialize(sender, nil)
+
+		metricSink = sink.NewDropsondeSink()
+	})
+
+	It("sends gauges with their unit", func() {
+		metricSink.Gauge("ETCDSentBandwidthRate", 3, sink.BytesPerSecond, nil)
+
+		Expect(sender.GetValue("ETCDSentBandwidthRate")).To(Equal(fake.Metric{
+			Value: 3,
+			Unit:  "B/s",
+		}))
+	})
+
+	It("sends durations in nanoseconds", func() {
+		metricSink.Duration("MetricsReportingDuration", time.Second, nil)
+
+		Expect(sender.GetValue("MetricsReportingDuration")).To(Equal(fake.Metric{
+			Value: float64(time.Second),
+			Unit:  "nanos",
+		}))
+	})
+
+	It("adds to counters", func() {
+		metricSink.Counter("SomeCounter", 2, nil)
+		metricSink.Counter("SomeCounter", 3, nil)
+
+		Expect(sender.GetCounter("SomeCounter")).To(BeEquivalentTo(5))
+	})
+
+	It("appends tag values to the name in tag key order", func() {
+		metricSink.Gauge("LRPsRunning", 4, sink.Metric, sink.Tags{"zone": "z1", "domain": "cf-apps"})
+
+		Expect(sender.GetValue("LRPsRunning.cf-apps.z1")).To(Equal(fake.Metric{
+			Value: 4,
+			Unit:  "Metric",
+		}))
+	})
+
+	It("marks stale values at the end of the name", func() {
+		metricSink.Gauge("LRPsRunning", 4, sink.Metric, sink.Tags{"domain": "cf-apps", sink.StaleTag: "true", "zone": "z1"})
+		metricSink.Gauge("LRPsDesired", 5, sink.Metric, sink.Tags{sink.StaleTag: "true"})
+
+		Expect(sender.GetValue("LRPsRunning")).To(BeZero())
+		Expect(sender.GetValue("LRPsDesired.stale")).To(Equal(fake.Metric{
+			Value: 5,
+			Unit:  "Metric",
+		}))
+		Expect(sender.GetValue("LRPsRunning.cf-apps.z1.stale")).To(Equal(fake.Metric{
+			Value: 4,
+			Unit:  "Metric",
+		}))
+	})
+})
@@ -0,0 +1,112 @@
+// This file was generated by counterfeiter
+package fake_sink
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
+)
+
+type FakeSink struct {
+	GaugeStub        func(name string, value float64, unit sink.Unit, tags sink.Tags)
+	gaugeMutex       sync.RWMutex
+	gaugeArgsForCall []struct {
+		name  string
+		value float64
+		unit  sink.Unit
+		tags  sink.Tags
+	}
+	CounterStub        func(name string, delta uint64, tags sink.Tags)
+	counterMutex       sync.RWMutex
+	counterArgsForCall []struct {
+		name  string
+		delta uint64
+		tags  sink.Tags
+	}
+	DurationStub        func(name string, duration time.Duration, tags sink.Tags)
+	durationMutex       sync.RWMutex
+	durationArgsForCall []struct {
+		name     string
+		duration time.Duration
+		tags     sink.Tags
+	}
+}
+
+func (fake *FakeSink) Gauge(name string, value float64, unit sink.Unit, tags sink.Tags) {
+	fake.gaugeMutex.Lock()
+	fake.gaugeArgsForCall = append(fake.gaugeArgsForCall, struct {
+		name  string
+		value float64
+		unit  sink.Unit
+		tags  sink.Tags
+	}{name, value, unit, tags})
+	fake.gaugeMutex.Unlock()
+	if fake.GaugeStub != nil {
+		fake.GaugeStub(name, value, unit, tags)
+	}
+}
+
+func (fake *FakeSink) GaugeCallCount() int {
+	fake.gaugeMutex.RLock()
+	defer fake.gaugeMutex.RUnlock()
+	return len(fake.gaugeArgsForCall)
+}
+
+func (fake *FakeSink) GaugeArgsForCall(i int) (string, float64, sink.Unit, sink.Tags) {
+	fake.gaugeMutex.RLock()
+	defer fake.gaugeMutex.RUnlock()
+	return fake.gaugeArgsForCall[i].name, fake.gaugeArgsForCall[i].value, fake.gaugeArgsForCall[i].unit, fake.gaugeArgsForCall[i].tags
+}
+
+func (fake *FakeSink) Counter(name string, delta uint64, tags sink.Tags) {
+	fake.counterMutex.Lock()
+	fake.counterArgsForCall = append(fake.counterArgsForCall, struct {
+		name  string
+		delta uint64
+		tags  sink.Tags
+	}{name, delta, tags})
+	fake.counterMutex.Unlock()
+	if fake.CounterStub != nil {
+		fake.CounterStub(name, delta, tags)
+	}
+}
+
+func (fake *FakeSink) CounterCallCount() int {
+	fake.counterMutex.RLock()
+	defer fake.counterMutex.RUnlock()
+	return len(fake.counterArgsForCall)
+}
+
+func (fake *FakeSink) CounterArgsForCall(i int) (string, uint64, sink.Tags) {
+	fake.counterMutex.RLock()
+	defer fake.counterMutex.RUnlock()
+	return fake.counterArgsForCall[i].name, fake.counterArgsForCall[i].delta, fake.counterArgsForCall[i].tags
+}
+
+func (fake *FakeSink) Duration(name string, duration time.Duration, tags sink.Tags) {
+	fake.durationMutex.Lock()
+	fake.durationArgsForCall = append(fake.durationArgsForCall, struct {
+		name     string
+		duration time.Duration
+		tags     sink.Tags
+	}{name, duration, tags})
+	fake.durationMutex.Unlock()
+	if fake.DurationStub != nil {
+		fake.DurationStub(name, duration, tags)
+	}
+}
+
+func (fake *FakeSink) DurationCallCount() int {
+	fake.durationMutex.RLock()
+	defer fake.durationMutex.RUnlock()
+	return len(fake.durationArgsForCall)
+}
+
+func (fake *FakeSink) DurationArgsForCall(i int) (string, time.Duration, sink.Tags) {
+	fake.durationMutex.RLock()
+	defer fake.durationMutex.RUnlock()
+	return fake.durationArgsForCall[i].name, fake.durationArgsForCall[i].duration, fake.durationArgsForCall[i].tags
+}
+
+var _ sink.Sink = new(FakeSink)
@@ -0,0 +1,28 @@
+package sink
+
+import "time"
+
+type fanOutSink []Sink
+
+// NewFanOutSink emits every metric to each of the given sinks in turn.
+func NewFanOutSink(sinks ...Sink) Sink {
+	return fanOutSink(sinks)
+}
+
+func (sinks fanOutSink) Gauge(name string, value float64, unit Unit, tags Tags) {
+	for _, s := range sinks {
+		s.Gauge(name, value, unit, tags)
+	}
+}
+
+func (sinks fanOutSink) Counter(name string, delta uint64, tags Tags) {
+	for _, s := range sinks {
+		s.Counter(name, delta, tags)
+	}
+}
+
+func (sinks fanOutSink) Duration(name string, duration time.Duration, tags Tags) {
+	for _, s := range sinks {
+		s.Duration(name, duration, tags)
+	}
+}
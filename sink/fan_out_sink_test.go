@@ -0,0 +1,66 @@
+package sink_test
+
+import (
+	"time"
+
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink/fake_sink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FanOutSink", func() {
+	var (
+		sink1 *fake_sink.FakeSink
+		sink2 *fake_sink.FakeSink
+
+		metricSink sink.Sink
+	)
+
+	BeforeEach(func() {
+		sink1 = new(fake_sink.FakeSink)
+		sink2 = new(fake_sink.FakeSink)
+
+		metricSink = sink.NewFanOutSink(sink1, sink2)
+	})
+
+	It("emits gauges to every sink", func() {
+		tags := sink.Tags{"domain": "cf-apps"}
+		metricSink.Gauge("LRPsRunning", 4, sink.Metric, tags)
+
+		for _, s := range []*fake_sink.FakeSink{sink1, sink2} {
+			Expect(s.GaugeCallCount()).To(Equal(1))
+
+			name, value, unit, emittedTags := s.GaugeArgsForCall(0)
+			Expect(name).To(Equal("LRPsRunning"))
+			Expect(value).To(Equal(4.0))
+			Expect(unit).To(Equal(sink.Metric))
+			Expect(emittedTags).To(Equal(tags))
+		}
+	})
+
+	It("emits counters to every sink", func() {
+		metricSink.Counter("SomeCounter", 2, nil)
+
+		for _, s := range []*fake_sink.FakeSink{sink1, sink2} {
+			Expect(s.CounterCallCount()).To(Equal(1))
+
+			name, delta, _ := s.CounterArgsForCall(0)
+			Expect(name).To(Equal("SomeCounter"))
+			Expect(delta).To(BeEquivalentTo(2))
+		}
+	})
+
+	It("emits durations to every sink", func() {
+		metricSink.Duration("MetricsReportingDuration", time.Second, nil)
+
+		for _, s := range []*fake_sink.FakeSink{sink1, sink2} {
+			Expect(s.DurationCallCount()).To(Equal(1))
+
+			name, duration, _ := s.DurationArgsForCall(0)
+			Expect(name).To(Equal("MetricsReportingDuration"))
+			Expect(duration).To(Equal(time.Second))
+		}
+	})
+})
@@ -0,0 +1,173 @@
+package sink
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type metricKind int
+
+const (
+	gaugeKind metricKind = iota
+	counterKind
+	durationKind
+)
+
+type recordedMetric struct {
+	kind     metricKind
+	name     string
+	value    float64
+	unit     Unit
+	delta    uint64
+	duration time.Duration
+	tags     Tags
+}
+
+// Recording is a sequence of emitted metrics that can be replayed.
+type Recording []recordedMetric
+
+// RecordingSink buffers every metric emitted through it, rather than sending
+// it anywhere.
+type RecordingSink struct {
+	lock     sync.Mutex
+	recorded Recording
+}
+
+func NewRecordingSink() *RecordingSink {
+	return &RecordingSink{}
+}
+
+func (s *RecordingSink) Gauge(name string, value float64, unit Unit, tags Tags) {
+	s.record(recordedMetric{kind: gaugeKind, name: name, value: value, unit: unit, tags: tags})
+}
+
+func (s *RecordingSink) Counter(name string, delta uint64, tags Tags) {
+	s.record(recordedMetric{kind: counterKind, name: name, delta: delta, tags: tags})
+}
+
+func (s *RecordingSink) Duration(name string, duration time.Duration, tags Tags) {
+	s.record(recordedMetric{kind: durationKind, name: name, duration: duration, tags: tags})
+}
+
+func (s *RecordingSink) record(metric recordedMetric) {
+	s.lock.Lock()
+	s.recorded = append(s.recorded, metric)
+	s.lock.Unlock()
+}
+
+// Take returns everything recorded so far, and starts a new recording.
+func (s *RecordingSink) Take() Recording {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	recorded := s.recorded
+	s.recorded = nil
+
+	return recorded
+}
+
+// Values drops the counters from the recording. Counters report deltas, so
+// replaying them would count the same events twice.
+func (r Recording) Values() Recording {
+	var values Recording
+	for _, metric := range r {
+		if metric.kind != counterKind {
+			values = append(values, metric)
+		}
+	}
+
+	return values
+}
+
+// Counters keeps only the counters from the recording.
+func (r Recording) Counters() Recording {
+	var counters Recording
+	for _, metric := range r {
+		if metric.kind == counterKind {
+			counters = append(counters, metric)
+		}
+	}
+
+	return counters
+}
+
+// WithoutGauges drops the gauges that report the given value.
+func (r Recording) WithoutGauges(value float64) Recording {
+	var kept Recording
+	for _, metric := range r {
+		if metric.kind != gaugeKind || metric.value != value {
+			kept = append(kept, metric)
+		}
+	}
+
+	return kept
+}
+
+// Except drops the metrics that the other recording has as well, going by
+// their names and tags.
+func (r Recording) Except(other Recording) Recording {
+	seen := make(map[string]bool, len(other))
+	for _, metric := range other {
+		seen[metric.series()] = true
+	}
+
+	var kept Recording
+	for _, metric := range r {
+		if !seen[metric.series()] {
+			kept = append(kept, metric)
+		}
+	}
+
+	return kept
+}
+
+// Replay emits the recorded metrics to the sink in the order they were
+// recorded, adding the given tags to each of them.
+func (r Recording) Replay(target Sink, extraTags Tags) {
+	for _, metric := range r {
+		tags := mergeTags(metric.tags, extraTags)
+
+		switch metric.kind {
+		case gaugeKind:
+			target.Gauge(metric.name, metric.value, metric.unit, tags)
+		case counterKind:
+			target.Counter(metric.name, metric.delta, tags)
+		case durationKind:
+			target.Duration(metric.name, metric.duration, tags)
+		}
+	}
+}
+
+func mergeTags(tags, extraTags Tags) Tags {
+	if len(extraTags) == 0 {
+		return tags
+	}
+
+	merged := make(Tags, len(tags)+len(extraTags))
+	for key, value := range tags {
+		merged[key] = value
+	}
+	for key, value := range extraTags {
+		merged[key] = value
+	}
+
+	return merged
+}
+
+// series identifies the metric by its name and tags.
+func (m recordedMetric) series() string {
+	keys := make([]string, 0, len(m.tags))
+	for key := range m.tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := []string{m.name}
+	for _, key := range keys {
+		parts = append(parts, key+"="+m.tags[key])
+	}
+
+	return strings.Join(parts, "\x00")
+}
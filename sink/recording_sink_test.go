@@ -0,0 +1,119 @@
+package sink_test
+
+import (
+	"time"
+
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink"
+	"github.com/cloudfoundry-incubator/runtime-metrics-server/sink/fake_sink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RecordingSink", func() {
+	var (
+		recordingSink *sink.RecordingSink
+		target        *fake_sink.FakeSink
+	)
+
+	BeforeEach(func() {
+		recordingSink = sink.NewRecordingSink()
+		target = new(fake_sink.FakeSink)
+
+		recordingSink.Gauge("LRPsRunning", 4, sink.Metric, sink.Tags{"domain": "cf-apps"})
+		recordingSink.Counter("SomeCounter", 2, nil)
+		recordingSink.Duration("InstrumentDuration", time.Second, nil)
+	})
+
+	It("replays everything recorded", func() {
+		recordingSink.Take().Replay(target, nil)
+
+		Expect(target.GaugeCallCount()).To(Equal(1))
+		name, value, unit, tags := target.GaugeArgsForCall(0)
+		Expect(name).To(Equal("LRPsRunning"))
+		Expect(value).To(Equal(4.0))
+		Expect(unit).To(Equal(sink.Metric))
+		Expect(tags).To(Equal(sink.Tags{"domain": "cf-apps"}))
+
+		Expect(target.CounterCallCount()).To(Equal(1))
+		name, delta, _ := target.CounterArgsForCall(0)
+		Expect(name).To(Equal("SomeCounter"))
+		Expect(delta).To(BeEquivalentTo(2))
+
+		Expect(target.DurationCallCount()).To(Equal(1))
+		name, duration, _ := target.DurationArgsForCall(0)
+		Expect(name).To(Equal("InstrumentDuration"))
+		Expect(duration).To(Equal(time.Second))
+	})
+
+	It("starts a new recording once taken", func() {
+		recordingSink.Take()
+		recordingSink.Take().Replay(target, nil)
+
+		Expect(target.GaugeCallCount()).To(BeZero())
+		Expect(target.CounterCallCount()).To(BeZero())
+		Expect(target.DurationCallCount()).To(BeZero())
+	})
+
+	It("adds the extra tags without modifying the recorded ones", func() {
+		recording := recordingSink.Take()
+		recording.Replay(target, sink.Tags{sink.StaleTag: "true"})
+		recording.Replay(target, nil)
+
+		_, _, _, tags := target.GaugeArgsForCall(0)
+		Expect(tags).To(Equal(sink.Tags{"domain": "cf-apps", sink.StaleTag: "true"}))
+
+		_, _, _, tags = target.GaugeArgsForCall(1)
+		Expect(tags).To(Equal(sink.Tags{"domain": "cf-apps"}))
+	})
+
+	Describe("Values", func() {
+		It("drops the counters", func() {
+			recordingSink.Take().Values().Replay(target, nil)
+
+			Expect(target.GaugeCallCount()).To(Equal(1))
+			Expect(target.DurationCallCount()).To(Equal(1))
+			Expect(target.CounterCallCount()).To(BeZero())
+		})
+	})
+
+	Describe("Counters", func() {
+		It("keeps only the counters", func() {
+			recordingSink.Take().Counters().Replay(target, nil)
+
+			Expect(target.CounterCallCount()).To(Equal(1))
+			Expect(target.GaugeCallCount()).To(BeZero())
+			Expect(target.DurationCallCount()).To(BeZero())
+		})
+	})
+
+	Describe("WithoutGauges", func() {
+		It("drops the gauges reporting the value", func() {
+			recordingSink.Gauge("LRPsRunning", -1, sink.Metric, nil)
+			recordingSink.Take().WithoutGauges(-1).Replay(target, nil)
+
+			Expect(target.GaugeCallCount()).To(Equal(1))
+			_, value, _, _ := target.GaugeArgsForCall(0)
+			Expect(value).To(Equal(4.0))
+
+			Expect(target.CounterCallCount()).To(Equal(1))
+			Expect(target.DurationCallCount()).To(Equal(1))
+		})
+	})
+
+	Describe("Except", func() {
+		It("drops the metrics with the same name and tags as the other recording's", func() {
+			recording := recordingSink.Take()
+
+			recordingSink.Gauge("LRPsRunning", 5, sink.Metric, sink.Tags{"domain": "cf-apps"})
+			recordingSink.Gauge("LRPsRunning", 6, sink.Metric, sink.Tags{"domain": "cf-tasks"})
+			recordingSink.Gauge("InstrumentDuration", 7, sink.Metric, sink.Tags{"instrument": "tasks"})
+
+			recording.Except(recordingSink.Take()).Replay(target, nil)
+
+			Expect(target.GaugeCallCount()).To(BeZero())
+			Expect(target.CounterCallCount()).To(Equal(1))
+			Expect(target.DurationCallCount()).To(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,30 @@
+package sink
+
+import "time"
+
+// Unit describes what a gauge measures. The values match the units that
+// runtime-schema's metric kinds report to dropsonde.
+type Unit string
+
+const (
+	Metric            Unit = "Metric"
+	BytesPerSecond    Unit = "B/s"
+	RequestsPerSecond Unit = "Req/s"
+	Mebibytes         Unit = "MiB"
+	Bytes             Unit = "B"
+)
+
+// Tags qualify a metric, e.g. with the domain or cell it describes.
+type Tags map[string]string
+
+// StaleTag marks values that were collected on an earlier cycle and are being
+// reported again because collection is currently failing.
+const StaleTag = "stale"
+
+//go:generate counterfeiter -o fake_sink/fake_sink.go . Sink
+
+type Sink interface {
+	Gauge(name string, value float64, unit Unit, tags Tags)
+	Counter(name string, delta uint64, tags Tags)
+	Duration(name string, duration time.Duration, tags Tags)
+}